@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	alertPending  = "pending"
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	// how long a resolved alert is kept around before it is forgotten
+	alertRetention = 15 * time.Minute
+)
+
+// rule describes a threshold on one of the metrics exported by the monitor, for example
+// {"name": "QueueBacklog", "metric": "rmq_queue_messages", "operator": ">", "threshold": 1000, "for": "5m"}
+type rule struct {
+	Name        string            `json:"name"`
+	Metric      string            `json:"metric"`
+	Match       map[string]string `json:"match"` // only series having exactly these label values are evaluated
+	Operator    string            `json:"operator"`
+	Threshold   float64           `json:"threshold"`
+	For         duration          `json:"for"` // how long the condition has to hold before the alert fires
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"` // go templates over the alert labels and value
+
+	annotationTemplates map[string]*template.Template
+}
+
+// duration decodes durations written as strings like "5m"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+type alert struct {
+	Name        string            `json:"name"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Value       float64           `json:"value"`
+	ActiveAt    time.Time         `json:"activeAt"`
+	FiredAt     time.Time         `json:"firedAt"`
+	ResolvedAt  time.Time         `json:"resolvedAt"`
+}
+
+// notifier delivers alerts somewhere outside of the monitor, it is handed all the alerts after every evaluation
+type notifier interface {
+	notify(alerts []alert, now time.Time)
+}
+
+// alertEngine evaluates the rules against the gathered metrics and keeps track of the alerts they raise
+type alertEngine struct {
+	sync.Mutex
+	rules     []*rule
+	notifiers []notifier
+	alerts    map[string]*alert // keyed by the labels of the alert
+
+	evaluating sync.Mutex // the notifiers are not safe to call concurrently, scans of several clusters can finish at once
+}
+
+var alerts = &alertEngine{alerts: map[string]*alert{}}
+
+// reads the rules from a json file holding a list of rules
+func loadRules(path string) ([]*rule, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*rule{}
+	if err := json.Unmarshal(contents, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if err := r.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// validates the rule and parses its annotation templates
+func (r *rule) prepare() error {
+	if r.Name == "" || r.Metric == "" {
+		return fmt.Errorf("rule %q needs both a name and a metric", r.Name)
+	}
+	if _, err := compare(r.Operator, 0, 0); err != nil {
+		return fmt.Errorf("rule %q: %s", r.Name, err)
+	}
+	r.annotationTemplates = map[string]*template.Template{}
+	for name, text := range r.Annotations {
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return fmt.Errorf("rule %q, annotation %q: %s", r.Name, name, err)
+		}
+		r.annotationTemplates[name] = tmpl
+	}
+	return nil
+}
+
+// builtinRules are used when no rules file is given, they cover the failures every cluster should be alerting on.
+// They follow the metrics into the configured namespace and naming mode
+func builtinRules() []*rule {
+	rules := []*rule{
+		{
+			Name:        "RabbitmqApiUnreachable",
+			Metric:      "rmq_api_reachable",
+			Operator:    "==",
+			Threshold:   0,
+			For:         duration(time.Minute),
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "The management api of {{ .Labels.cluster_name }} is unreachable"},
+		},
+		{
+			Name:        "RabbitmqCoreUnreachable",
+			Metric:      "rmq_core_reachable",
+			Operator:    "==",
+			Threshold:   0,
+			For:         duration(time.Minute),
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "{{ .Labels.cluster_name }} does not accept amqp connections"},
+		},
+		{
+			Name:        "RabbitmqMemoryAlarm",
+			Metric:      "rmq_node_mem_alarm",
+			Operator:    "==",
+			Threshold:   1,
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "The memory alarm is active on {{ .Labels.node }}, publishers are blocked"},
+		},
+		{
+			Name:        "RabbitmqDiskAlarm",
+			Metric:      "rmq_node_disk_alarm",
+			Operator:    "==",
+			Threshold:   1,
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "The disk alarm is active on {{ .Labels.node }}, publishers are blocked"},
+		},
+		{
+			Name:        "RabbitmqQueueStuck",
+			Metric:      "rmq_queue_status",
+			Match:       map[string]string{"status": queueStuck},
+			Operator:    "==",
+			Threshold:   1,
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "The consumers of {{ .Labels.queue }} in {{ .Labels.vhost }} are not making any progress"},
+		},
+	}
+	for _, r := range rules {
+		r.Metric = exportName(r.Metric)
+		if err := r.prepare(); err != nil {
+			panic(err)
+		}
+	}
+	return rules
+}
+
+// compare applies the operator of a rule to the value and the threshold
+func compare(operator string, value, threshold float64) (bool, error) {
+	switch operator {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", operator)
+}
+
+func (r *rule) matches(labels map[string]string) bool {
+	for name, value := range r.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// builds the annotations of an alert, falling back to the raw text when a template fails
+func (r *rule) annotate(a *alert) map[string]string {
+	annotations := map[string]string{}
+	for name, tmpl := range r.annotationTemplates {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, a); err != nil {
+			annotations[name] = r.Annotations[name]
+			continue
+		}
+		annotations[name] = out.String()
+	}
+	return annotations
+}
+
+// alertKey identifies an alert by its sorted labels
+func alertKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// metricValue returns the value of a gauge, counter or untyped metric
+func metricValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+// evaluates all the rules against the current metrics, runs after every scan
+func (e *alertEngine) evaluate(now time.Time) {
+	if len(e.rules) == 0 {
+		return
+	}
+	e.evaluating.Lock()
+	defer e.evaluating.Unlock()
+
+	// a partial gather still holds everything that could be collected
+	families, err := metricLabels.Gather()
+	if err != nil {
+		log.Println("Gathering metrics for the alert rules failed:", err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+
+	e.update(byName, now)
+
+	if len(e.notifiers) == 0 {
+		return
+	}
+	current := e.list()
+	for _, n := range e.notifiers {
+		n.notify(current, now)
+	}
+}
+
+// moves the alerts through their states based on the gathered metrics
+func (e *alertEngine) update(byName map[string]*dto.MetricFamily, now time.Time) {
+	e.Lock()
+	defer e.Unlock()
+
+	active := map[string]bool{}
+	for _, r := range e.rules {
+		family, exists := byName[r.Metric]
+		if !exists {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if !r.matches(labels) {
+				continue
+			}
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			if holds, _ := compare(r.Operator, value, r.Threshold); !holds {
+				continue
+			}
+
+			for name, value := range r.Labels {
+				labels[name] = value
+			}
+			labels["alertname"] = r.Name
+			key := alertKey(labels)
+			active[key] = true
+
+			a, exists := e.alerts[key]
+			if !exists || a.State == alertResolved {
+				a = &alert{Name: r.Name, State: alertPending, Labels: labels, ActiveAt: now}
+				e.alerts[key] = a
+			}
+			a.Value = value
+			a.Annotations = r.annotate(a)
+			if a.State == alertPending && now.Sub(a.ActiveAt) >= time.Duration(r.For) {
+				a.State = alertFiring
+				a.FiredAt = now
+			}
+		}
+	}
+
+	for key, a := range e.alerts {
+		if active[key] {
+			continue
+		}
+		switch a.State {
+		case alertPending:
+			delete(e.alerts, key)
+		case alertFiring:
+			a.State = alertResolved
+			a.ResolvedAt = now
+		case alertResolved:
+			if now.Sub(a.ResolvedAt) > alertRetention {
+				delete(e.alerts, key)
+			}
+		}
+	}
+}
+
+// list returns a copy of the known alerts, oldest first
+func (e *alertEngine) list() []alert {
+	e.Lock()
+	defer e.Unlock()
+
+	list := make([]alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ActiveAt.Before(list[j].ActiveAt)
+	})
+	return list
+}
+
+// serves the pending, firing and recently resolved alerts as json
+func (e *alertEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"alerts": e.list()})
+}
+
+// Describe sends nothing, the labels of the ALERTS series depend on the rules that fired
+func (e *alertEngine) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect exports the pending and firing alerts the same way prometheus does, as ALERTS{alertname, alertstate, ...}
+func (e *alertEngine) Collect(ch chan<- prometheus.Metric) {
+	current := []alert{}
+	for _, a := range e.list() {
+		if a.State != alertResolved {
+			current = append(current, a)
+		}
+	}
+
+	// every series of a metric needs the same label names, missing labels are reported empty
+	nameSet := map[string]bool{"alertstate": true}
+	for _, a := range current {
+		for name := range a.Labels {
+			nameSet[name] = true
+		}
+	}
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	desc := prometheus.NewDesc("ALERTS", "The pending and firing alerts of the monitor", names, nil)
+	for _, a := range current {
+		values := make([]string, len(names))
+		for i, name := range names {
+			if name == "alertstate" {
+				values[i] = a.State
+			} else {
+				values[i] = a.Labels[name]
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// series is a single sample of a gauge handed to the alert engine
+type series struct {
+	labels prometheus.Labels
+	value  float64
+}
+
+// gather builds the metric families the engine evaluates, the way the exporter gathers them
+func gather(t *testing.T, name string, labelNames []string, samples ...series) map[string]*dto.MetricFamily {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: "test gauge"}, labelNames)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge)
+	for _, s := range samples {
+		gauge.With(s.labels).Set(s.value)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering the test gauge failed: %s", err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	return byName
+}
+
+func newTestEngine(t *testing.T, r *rule) *alertEngine {
+	if err := r.prepare(); err != nil {
+		t.Fatalf("preparing the rule failed: %s", err)
+	}
+	return &alertEngine{rules: []*rule{r}, alerts: map[string]*alert{}}
+}
+
+func backlogRule() *rule {
+	return &rule{
+		Name:        "QueueBacklog",
+		Metric:      "rmq_queue_messages",
+		Operator:    ">",
+		Threshold:   1000,
+		For:         duration(2 * time.Minute),
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "{{ .Labels.queue }} holds {{ .Value }} messages"},
+	}
+}
+
+func TestAlertEngineMovesThroughTheStates(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		description string
+		after       time.Duration
+		value       float64
+		state       string // empty when the alert should not be known
+	}{
+		{"the condition starts holding", 0, 2000, alertPending},
+		{"it holds for less than the for duration", time.Minute, 3000, alertPending},
+		{"it held for the whole for duration", 2 * time.Minute, 3000, alertFiring},
+		{"it keeps holding", 3 * time.Minute, 1500, alertFiring},
+		{"the condition stops holding", 4 * time.Minute, 10, alertResolved},
+		{"it starts holding again", 5 * time.Minute, 2000, alertPending},
+		{"it stops holding before firing", 6 * time.Minute, 10, ""},
+	}
+
+	engine := newTestEngine(t, backlogRule())
+	labels := prometheus.Labels{"queue": "orders"}
+	for _, step := range steps {
+		now := start.Add(step.after)
+		engine.update(gather(t, "rmq_queue_messages", []string{"queue"}, series{labels, step.value}), now)
+
+		list := engine.list()
+		if step.state == "" {
+			if len(list) != 0 {
+				t.Errorf("%s: expected no alert, got %+v", step.description, list)
+			}
+			continue
+		}
+		if len(list) != 1 {
+			t.Fatalf("%s: expected a single alert, got %+v", step.description, list)
+		}
+		a := list[0]
+		if a.State != step.state {
+			t.Errorf("%s: expected state %s, got %s", step.description, step.state, a.State)
+		}
+		switch a.State {
+		case alertFiring:
+			if !a.FiredAt.Equal(start.Add(2 * time.Minute)) {
+				t.Errorf("%s: expected the alert to have fired at %s, got %s", step.description, start.Add(2*time.Minute), a.FiredAt)
+			}
+		case alertResolved:
+			if !a.ResolvedAt.Equal(now) {
+				t.Errorf("%s: expected the alert to be resolved at %s, got %s", step.description, now, a.ResolvedAt)
+			}
+		}
+	}
+}
+
+func TestAlertEngineFiresRightAwayWithoutFor(t *testing.T) {
+	r := backlogRule()
+	r.For = 0
+	engine := newTestEngine(t, r)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	engine.update(gather(t, "rmq_queue_messages", []string{"queue"}, series{prometheus.Labels{"queue": "orders"}, 2000}), now)
+	list := engine.list()
+	if len(list) != 1 || list[0].State != alertFiring {
+		t.Fatalf("expected a firing alert, got %+v", list)
+	}
+	if list[0].Annotations["summary"] != "orders holds 2000 messages" {
+		t.Errorf("unexpected annotations %v", list[0].Annotations)
+	}
+	if list[0].Labels["severity"] != "warning" || list[0].Labels["alertname"] != "QueueBacklog" {
+		t.Errorf("expected the rule labels and the alertname on the alert, got %v", list[0].Labels)
+	}
+}
+
+func TestAlertEngineForgetsResolvedAlertsAfterTheRetention(t *testing.T) {
+	r := backlogRule()
+	r.For = 0
+	resolvedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description string
+		after       time.Duration
+		kept        bool
+	}{
+		{"right after resolving", 0, true},
+		{"at the end of the retention", alertRetention, true},
+		{"past the retention", alertRetention + time.Second, false},
+	}
+
+	for _, test := range tests {
+		engine := newTestEngine(t, r)
+		firing := gather(t, "rmq_queue_messages", []string{"queue"}, series{prometheus.Labels{"queue": "orders"}, 2000})
+		quiet := gather(t, "rmq_queue_messages", []string{"queue"}, series{prometheus.Labels{"queue": "orders"}, 0})
+		engine.update(firing, resolvedAt.Add(-time.Minute))
+		engine.update(quiet, resolvedAt)
+		engine.update(quiet, resolvedAt.Add(test.after))
+
+		list := engine.list()
+		if test.kept && (len(list) != 1 || list[0].State != alertResolved) {
+			t.Errorf("%s: expected the resolved alert to be kept, got %+v", test.description, list)
+		}
+		if !test.kept && len(list) != 0 {
+			t.Errorf("%s: expected the resolved alert to be forgotten, got %+v", test.description, list)
+		}
+	}
+}
+
+func TestAlertEngineMatchesTheLabels(t *testing.T) {
+	r := &rule{
+		Name:      "QueueStuck",
+		Metric:    "rmq_queue_status",
+		Match:     map[string]string{"status": queueStuck, "vhost": "/"},
+		Operator:  "==",
+		Threshold: 1,
+	}
+	tests := []struct {
+		description string
+		labels      prometheus.Labels
+		value       float64
+		alerting    bool
+	}{
+		{"every matcher holds", prometheus.Labels{"vhost": "/", "queue": "orders", "status": queueStuck}, 1, true},
+		{"another status", prometheus.Labels{"vhost": "/", "queue": "orders", "status": queueOK}, 1, false},
+		{"another vhost", prometheus.Labels{"vhost": "billing", "queue": "orders", "status": queueStuck}, 1, false},
+		{"matching labels below the threshold", prometheus.Labels{"vhost": "/", "queue": "orders", "status": queueStuck}, 0, false},
+	}
+
+	for _, test := range tests {
+		engine := newTestEngine(t, r)
+		engine.update(gather(t, "rmq_queue_status", []string{"vhost", "queue", "status"}, series{test.labels, test.value}), time.Now())
+		if alerting := len(engine.list()) == 1; alerting != test.alerting {
+			t.Errorf("%s: expected alerting %v, got %+v", test.description, test.alerting, engine.list())
+		}
+	}
+}
+
+func TestAlertEngineExportsTheAlertsSeries(t *testing.T) {
+	engine := &alertEngine{alerts: map[string]*alert{}}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, a := range []alert{
+		{Name: "QueueBacklog", State: alertFiring, Labels: map[string]string{"alertname": "QueueBacklog", "queue": "orders"}, ActiveAt: now},
+		{Name: "NodeDown", State: alertPending, Labels: map[string]string{"alertname": "NodeDown", "node": "rabbit@a"}, ActiveAt: now.Add(time.Second)},
+		{Name: "QueueBacklog", State: alertResolved, Labels: map[string]string{"alertname": "QueueBacklog", "queue": "billing"}, ActiveAt: now.Add(2 * time.Second)},
+	} {
+		a := a
+		engine.alerts[alertKey(a.Labels)] = &a
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(engine)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering the alerts failed: %s", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "ALERTS" {
+		t.Fatalf("expected only the ALERTS family, got %v", families)
+	}
+
+	expected := map[string]map[string]string{
+		"QueueBacklog": {"alertname": "QueueBacklog", "alertstate": alertFiring, "node": "", "queue": "orders"},
+		"NodeDown":     {"alertname": "NodeDown", "alertstate": alertPending, "node": "rabbit@a", "queue": ""},
+	}
+	metrics := families[0].GetMetric()
+	if len(metrics) != len(expected) {
+		t.Fatalf("expected the resolved alert to be left out, got %d series", len(metrics))
+	}
+	for _, m := range metrics {
+		labels := map[string]string{}
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		want := expected[labels["alertname"]]
+		if len(labels) != len(want) {
+			t.Errorf("expected the labels %v, got %v", want, labels)
+			continue
+		}
+		for name, value := range want {
+			if labels[name] != value {
+				t.Errorf("expected %s=%q on %s, got %q", name, value, labels["alertname"], labels[name])
+			}
+		}
+		if m.GetGauge().GetValue() != 1 {
+			t.Errorf("expected every ALERTS series to be 1, got %v", m.GetGauge().GetValue())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alertmanagerAlert is the payload accepted by the /api/v2/alerts endpoint of alertmanager
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertmanagerNotifier pushes the firing and resolved alerts to an alertmanager compatible endpoint. Alerts are
+// sent as soon as they change state and re-sent every resendInterval, so alertmanager does not expire them.
+type alertmanagerNotifier struct {
+	URL            string // the base url of alertmanager, without the /api/v2/alerts path
+	ResendInterval time.Duration
+	Client         *http.Client
+
+	sent     map[string]string // the state each alert had when it was last sent
+	lastSent time.Time
+}
+
+func newAlertmanagerNotifier(url string, resendInterval time.Duration) *alertmanagerNotifier {
+	return &alertmanagerNotifier{
+		URL:            strings.TrimRight(url, "/"),
+		ResendInterval: resendInterval,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+		sent:           map[string]string{},
+	}
+}
+
+func (am *alertmanagerNotifier) notify(alerts []alert, now time.Time) {
+	changed := false
+	current := map[string]string{}
+	payload := []alertmanagerAlert{}
+	for _, a := range alerts {
+		if a.State == alertPending {
+			continue
+		}
+		key := alertKey(a.Labels)
+		current[key] = a.State
+		if am.sent[key] != a.State {
+			changed = true
+		}
+		payload = append(payload, am.convert(a, now))
+	}
+	if len(payload) == 0 {
+		am.sent = current
+		return
+	}
+	if !changed && now.Sub(am.lastSent) < am.ResendInterval {
+		return
+	}
+
+	if err := am.send(payload); err != nil {
+		log.Println("Sending alerts to alertmanager failed:", err)
+		return
+	}
+	am.sent = current
+	am.lastSent = now
+}
+
+// convert maps an alert on the alertmanager payload. Firing alerts end a few resends in the future so
+// alertmanager resolves them by itself when the monitor goes away.
+func (am *alertmanagerNotifier) convert(a alert, now time.Time) alertmanagerAlert {
+	// an empty label is the same as a missing one, alertmanager should not see them
+	labels := map[string]string{}
+	for name, value := range a.Labels {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	converted := alertmanagerAlert{
+		Labels:      labels,
+		Annotations: a.Annotations,
+		StartsAt:    a.FiredAt,
+		EndsAt:      now.Add(4 * am.ResendInterval),
+	}
+	if a.State == alertResolved {
+		converted.EndsAt = a.ResolvedAt
+	}
+	return converted
+}
+
+// posts the alerts to alertmanager
+func (am *alertmanagerNotifier) send(payload []alertmanagerAlert) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := am.Client.Post(am.URL+"/api/v2/alerts", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
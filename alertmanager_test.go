@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAlertmanager records every batch of alerts posted to it
+type fakeAlertmanager struct {
+	sync.Mutex
+	server  *httptest.Server
+	batches [][]alertmanagerAlert
+}
+
+func newFakeAlertmanager(t *testing.T) *fakeAlertmanager {
+	am := &fakeAlertmanager{}
+	am.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		batch := []alertmanagerAlert{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decoding the alerts failed: %s", err)
+		}
+		am.Lock()
+		am.batches = append(am.batches, batch)
+		am.Unlock()
+	}))
+	t.Cleanup(am.server.Close)
+	return am
+}
+
+func (am *fakeAlertmanager) received() [][]alertmanagerAlert {
+	am.Lock()
+	defer am.Unlock()
+	return append([][]alertmanagerAlert{}, am.batches...)
+}
+
+func firingAlert(firedAt time.Time) alert {
+	return alert{
+		Name:        "RabbitmqQueueTooLong",
+		State:       alertFiring,
+		Labels:      map[string]string{"alertname": "RabbitmqQueueTooLong", "cluster_name": "prod", "queue": "orders", "environment": ""},
+		Annotations: map[string]string{"summary": "orders holds too many messages"},
+		ActiveAt:    firedAt.Add(-time.Minute),
+		FiredAt:     firedAt,
+	}
+}
+
+func TestAlertmanagerNotifierSendsFiringAlerts(t *testing.T) {
+	fake := newFakeAlertmanager(t)
+	notifier := newAlertmanagerNotifier(fake.server.URL+"/", time.Minute)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	pending := firingAlert(now)
+	pending.State = alertPending
+	pending.Labels = map[string]string{"alertname": "RabbitmqNodeDown"}
+	notifier.notify([]alert{firingAlert(now), pending}, now)
+
+	batches := fake.received()
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("expected a single batch with the firing alert, got %+v", batches)
+	}
+	sent := batches[0][0]
+	if len(sent.Labels) != 3 || sent.Labels["queue"] != "orders" || sent.Labels["cluster_name"] != "prod" {
+		t.Errorf("unexpected labels %v", sent.Labels)
+	}
+	if _, exists := sent.Labels["environment"]; exists {
+		t.Errorf("the empty environment label should be left out, got %v", sent.Labels)
+	}
+	if sent.Annotations["summary"] != "orders holds too many messages" {
+		t.Errorf("unexpected annotations %v", sent.Annotations)
+	}
+	if !sent.StartsAt.Equal(now) {
+		t.Errorf("expected startsAt %s, got %s", now, sent.StartsAt)
+	}
+	if !sent.EndsAt.Equal(now.Add(4 * time.Minute)) {
+		t.Errorf("expected endsAt four resend intervals ahead, got %s", sent.EndsAt)
+	}
+}
+
+func TestAlertmanagerNotifierSendsResolvedAlerts(t *testing.T) {
+	fake := newFakeAlertmanager(t)
+	notifier := newAlertmanagerNotifier(fake.server.URL, time.Minute)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	a := firingAlert(now)
+	notifier.notify([]alert{a}, now)
+
+	resolvedAt := now.Add(10 * time.Second)
+	a.State = alertResolved
+	a.ResolvedAt = resolvedAt
+	notifier.notify([]alert{a}, resolvedAt)
+
+	batches := fake.received()
+	if len(batches) != 2 {
+		t.Fatalf("expected the resolved alert to be sent right away, got %d batches", len(batches))
+	}
+	if !batches[1][0].EndsAt.Equal(resolvedAt) {
+		t.Errorf("expected endsAt %s on the resolved alert, got %s", resolvedAt, batches[1][0].EndsAt)
+	}
+}
+
+func TestAlertmanagerNotifierResendsAfterTheInterval(t *testing.T) {
+	fake := newFakeAlertmanager(t)
+	notifier := newAlertmanagerNotifier(fake.server.URL, time.Minute)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := firingAlert(now)
+
+	notifier.notify([]alert{a}, now)
+	notifier.notify([]alert{a}, now.Add(30*time.Second))
+	if batches := fake.received(); len(batches) != 1 {
+		t.Fatalf("an unchanged alert should wait for the resend interval, got %d batches", len(batches))
+	}
+
+	later := now.Add(time.Minute)
+	notifier.notify([]alert{a}, later)
+	batches := fake.received()
+	if len(batches) != 2 {
+		t.Fatalf("expected the alert to be re-sent after the resend interval, got %d batches", len(batches))
+	}
+	if !batches[1][0].EndsAt.Equal(later.Add(4 * time.Minute)) {
+		t.Errorf("expected the re-sent alert to end four resend intervals after %s, got %s", later, batches[1][0].EndsAt)
+	}
+}
+
+func TestAlertmanagerNotifierRetriesAfterAFailedSend(t *testing.T) {
+	failing := true
+	var mu sync.Mutex
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	notifier := newAlertmanagerNotifier(server.URL, time.Minute)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := firingAlert(now)
+
+	notifier.notify([]alert{a}, now)
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	notifier.notify([]alert{a}, now.Add(time.Second))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 2 {
+		t.Errorf("expected the failed send to be retried on the next evaluation, got %d posts", posts)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the anomaly detection is off unless ANOMALY_DETECTION is set
+var (
+	anomalyDetection = false
+	anomalyAlpha     = 0.1 // the weight of the newest value in the moving baseline
+	anomalyThreshold = 4.0 // the score the built-in anomaly rules fire at
+)
+
+// a series is only scored once its baseline has seen this many values
+const anomalyWarmup = 10
+
+// ewma is an exponentially weighted moving mean and variance, the baseline a series is compared against
+type ewma struct {
+	Mean     float64
+	Variance float64
+	Samples  int
+}
+
+// observe scores the value against the baseline and then moves the baseline towards it
+func (e *ewma) observe(value float64) (float64, bool) {
+	if e.Samples == 0 {
+		e.Mean, e.Samples = value, 1
+		return 0, false
+	}
+
+	// a flat series would make every change infinitely anomalous, the deviation never drops below 1% of the mean or 1
+	deviation := math.Max(math.Sqrt(e.Variance), math.Max(math.Abs(e.Mean)*0.01, 1))
+	score := (value - e.Mean) / deviation
+
+	diff := value - e.Mean
+	increment := anomalyAlpha * diff
+	e.Mean += increment
+	e.Variance = (1 - anomalyAlpha) * (e.Variance + diff*increment)
+	e.Samples++
+	return score, e.Samples > anomalyWarmup
+}
+
+// anomalySample is a single value of a watched series
+type anomalySample struct {
+	gauge  string // the gauge the score is exported on
+	labels prometheus.Labels
+	value  float64
+}
+
+// anomalySamples picks the depth and publish rate of every queue and the memory of every node
+func anomalySamples(queues []queueOutput, nodes []nodeOutput) []anomalySample {
+	samples := []anomalySample{}
+	for _, q := range queues {
+		labels := prometheus.Labels{"vhost": q.Vhost, "node": q.Node, "queue": q.Name}
+		samples = append(samples, anomalySample{"queue", withLabel(labels, "metric", "messages"), float64(q.Messages)})
+		if q.Rates != nil {
+			samples = append(samples, anomalySample{"queue", withLabel(labels, "metric", "publish_rate"), q.Rates.Ingress})
+		}
+	}
+	for _, n := range nodes {
+		samples = append(samples, anomalySample{"node", prometheus.Labels{"node": n.Name, "metric": "memory"}, float64(n.MemCurrent)})
+	}
+	return samples
+}
+
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	copied := prometheus.Labels{name: value}
+	for n, v := range labels {
+		copied[n] = v
+	}
+	return copied
+}
+
+// anomalyDetector keeps the baseline of every watched series of a cluster and the scores of the last scan
+type anomalyDetector struct {
+	ClusterName string
+
+	baselines map[string]*ewma
+	scores    []anomalySample                // the scored samples of the last scan, the value being the score
+	series    map[string][]prometheus.Labels // the series set during the last update, per gauge
+}
+
+func newAnomalyDetector(clusterName string) *anomalyDetector {
+	return &anomalyDetector{ClusterName: clusterName, baselines: map[string]*ewma{}}
+}
+
+// scores the current state of the cluster, a fresh detector first learns the baselines from the history
+func (c *cluster) detectAnomalies(now time.Time) {
+	if c.Anomalies == nil {
+		c.Anomalies = newAnomalyDetector(c.ClusterName)
+		for _, s := range scanHistory.between(c.ClusterName, now.Add(-scanHistory.retention), now) {
+			c.Anomalies.observe(anomalySamples(s.Queues, s.Nodes))
+		}
+	}
+
+	queues := make([]queueOutput, 0, len(c.Queues))
+	for _, q := range c.Queues {
+		// the queues of a group have no series of their own to score
+		if !q.grouped {
+			queues = append(queues, q.output())
+		}
+	}
+	nodes := make([]nodeOutput, 0, len(c.Nodes))
+	for _, n := range c.Nodes {
+		nodes = append(nodes, n.output())
+	}
+	c.Anomalies.scores = c.Anomalies.observe(anomalySamples(queues, nodes))
+}
+
+// feeds the samples to their baselines and returns the ones that could be scored, forgetting the series that are gone
+func (d *anomalyDetector) observe(samples []anomalySample) []anomalySample {
+	scored := []anomalySample{}
+	seen := map[string]bool{}
+	for _, sample := range samples {
+		key := sample.gauge + "/" + alertKey(sample.labels)
+		seen[key] = true
+		baseline, exists := d.baselines[key]
+		if !exists {
+			baseline = &ewma{}
+			d.baselines[key] = baseline
+		}
+		if score, ready := baseline.observe(sample.value); ready {
+			scored = append(scored, anomalySample{sample.gauge, sample.labels, score})
+		}
+	}
+	for key := range d.baselines {
+		if !seen[key] {
+			delete(d.baselines, key)
+		}
+	}
+	return scored
+}
+
+func (d *anomalyDetector) updateMetrics() {
+	current := map[string][]prometheus.Labels{}
+	for _, score := range d.scores {
+		labels := withLabel(score.labels, "cluster_name", d.ClusterName)
+		labels["environment"] = environment
+		anomalyGauges[score.gauge].With(labels).Set(score.value)
+		current[score.gauge] = append(current[score.gauge], labels)
+	}
+
+	for name, previous := range d.series {
+		for _, labels := range previous {
+			if !containsLabels(current[name], labels) {
+				anomalyGauges[name].Delete(labels)
+			}
+		}
+	}
+	d.series = current
+}
+
+// anomalyRules fire on the scores that stray too far from the baseline, they are added to the built-in rules
+func anomalyRules() []*rule {
+	rules := []*rule{
+		{
+			Name:        "RabbitmqQueueAnomaly",
+			Metric:      "rmq_queue_anomaly_score",
+			Operator:    ">=",
+			Threshold:   anomalyThreshold,
+			For:         duration(5 * time.Minute),
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "The {{ .Labels.metric }} of {{ .Labels.queue }} in {{ .Labels.vhost }} is unusually high"},
+		},
+		{
+			Name:        "RabbitmqQueueAnomalyLow",
+			Metric:      "rmq_queue_anomaly_score",
+			Operator:    "<=",
+			Threshold:   -anomalyThreshold,
+			For:         duration(5 * time.Minute),
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "The {{ .Labels.metric }} of {{ .Labels.queue }} in {{ .Labels.vhost }} is unusually low"},
+		},
+		{
+			Name:        "RabbitmqNodeAnomaly",
+			Metric:      "rmq_node_anomaly_score",
+			Operator:    ">=",
+			Threshold:   anomalyThreshold,
+			For:         duration(5 * time.Minute),
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "The {{ .Labels.metric }} of {{ .Labels.node }} is unusually high"},
+		},
+	}
+	for _, r := range rules {
+		r.Metric = exportName(r.Metric)
+		if err := r.prepare(); err != nil {
+			panic(err)
+		}
+	}
+	return rules
+}
+
+var anomalyGauges = map[string]*prometheus.GaugeVec{
+	"queue": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_anomaly_score",
+			Help: "How many deviations the messages or publish rate of the queue are away from their moving baseline",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment", "metric"}),
+	"node": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_anomaly_score",
+			Help: "How many deviations the memory of the node is away from its moving baseline",
+		},
+		[]string{"cluster_name", "node", "environment", "metric"}),
+}
+
+func registerAnomalyMetrics() {
+	for _, p := range anomalyGauges {
+		prometheus.MustRegister(p)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type user struct {
+	Name         string  `json:"name"`
+	PasswordHash string  `json:"password_hash"`
+	Tags         tagList `json:"tags"`
+}
+
+type permission struct {
+	User      string `json:"user"`
+	Vhost     string `json:"vhost"`
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+type topicPermission struct {
+	User     string `json:"user"`
+	Vhost    string `json:"vhost"`
+	Exchange string `json:"exchange"`
+	Write    string `json:"write"`
+	Read     string `json:"read"`
+}
+
+// audit holds the users and permissions of the cluster. It runs on its own schedule, separate from the scan,
+// so it keeps its own list of vhosts instead of sharing the one from the cluster.
+type audit struct {
+	Users            []*user
+	Permissions      []*permission
+	TopicPermissions []*topicPermission
+	Vhosts           []*vhost
+	ClusterName      string
+
+	series map[string][]prometheus.Labels // the series set during the last update, per gauge
+}
+
+// fullAccess is the pattern that grants access to every resource
+const fullAccess = ".*"
+
+// retrieves the users and permissions and exports the audit metrics
+func (c *cluster) audit() {
+	log.Println("Auditing...")
+	localAudit := &audit{}
+	if err := c.apiGet("/api/users", &localAudit.Users); err != nil {
+		return
+	}
+	if err := c.apiGet("/api/permissions", &localAudit.Permissions); err != nil {
+		return
+	}
+	if err := c.apiGet("/api/topic-permissions", &localAudit.TopicPermissions); err != nil {
+		return
+	}
+	if err := c.apiGet("/api/vhosts?columns=name", &localAudit.Vhosts); err != nil {
+		return
+	}
+
+	if c.Audit == nil {
+		c.Audit = &audit{}
+	}
+	c.Audit.ClusterName = c.ClusterName
+	c.Audit.update(localAudit)
+	c.Audit.updateMetrics()
+}
+
+func (a *audit) update(localAudit *audit) {
+	a.Users = localAudit.Users
+	a.Permissions = localAudit.Permissions
+	a.TopicPermissions = localAudit.TopicPermissions
+	a.Vhosts = localAudit.Vhosts
+}
+
+func (a *audit) updateMetrics() {
+	current := map[string][]prometheus.Labels{}
+	set := func(name string, labels prometheus.Labels, value float64) {
+		labels["cluster_name"] = a.ClusterName
+		labels["environment"] = environment
+		auditGauges[name].With(labels).Set(value)
+		current[name] = append(current[name], labels)
+	}
+
+	usersByTag := map[string]int{}
+	for _, user := range a.Users {
+		if len(user.Tags) == 0 {
+			usersByTag["none"]++
+		}
+		for _, tag := range user.Tags {
+			usersByTag[tag]++
+		}
+		if user.PasswordHash == "" {
+			set("user_without_password", prometheus.Labels{"user": user.Name}, 1)
+		}
+	}
+	for tag, count := range usersByTag {
+		set("users", prometheus.Labels{"tag": tag}, float64(count))
+	}
+
+	permittedVhosts := map[string]bool{}
+	for _, permission := range a.Permissions {
+		permittedVhosts[permission.Vhost] = true
+		if permission.Configure == fullAccess && permission.Write == fullAccess && permission.Read == fullAccess {
+			set("user_full_permissions", prometheus.Labels{"user": permission.User, "vhost": permission.Vhost}, 1)
+		}
+	}
+	for _, permission := range a.TopicPermissions {
+		if permission.Write == fullAccess && permission.Read == fullAccess {
+			set("user_full_topic_permissions", prometheus.Labels{"user": permission.User, "vhost": permission.Vhost, "exchange": permission.Exchange}, 1)
+		}
+	}
+	for _, vhost := range a.Vhosts {
+		if !permittedVhosts[vhost.Name] {
+			set("vhost_without_users", prometheus.Labels{"vhost": vhost.Name}, 1)
+		}
+	}
+
+	// users and permissions come and go, drop whatever was not reported this time
+	for name, previous := range a.series {
+		for _, labels := range previous {
+			if !containsLabels(current[name], labels) {
+				auditGauges[name].Delete(labels)
+			}
+		}
+	}
+	a.series = current
+}
+
+// containsLabels reports whether the list holds the given label set
+func containsLabels(list []prometheus.Labels, labels prometheus.Labels) bool {
+	for _, l := range list {
+		if sameLabels(l, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+var auditGauges = map[string]*prometheus.GaugeVec{
+	"users": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_audit_users",
+			Help: "Number of users having the tag, users without tags are counted under none",
+		},
+		[]string{"cluster_name", "environment", "tag"}),
+	"user_without_password": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_audit_user_without_password",
+			Help: "Set for every user that has no password",
+		},
+		[]string{"cluster_name", "environment", "user"}),
+	"user_full_permissions": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_audit_user_full_permissions",
+			Help: "Set for every user that can configure, write and read everything in the vhost",
+		},
+		[]string{"cluster_name", "environment", "user", "vhost"}),
+	"user_full_topic_permissions": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_audit_user_full_topic_permissions",
+			Help: "Set for every user that can write and read every routing key on the topic exchange",
+		},
+		[]string{"cluster_name", "environment", "user", "vhost", "exchange"}),
+	"vhost_without_users": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_audit_vhost_without_users",
+			Help: "Set for every vhost that no user has permissions on",
+		},
+		[]string{"cluster_name", "environment", "vhost"}),
+}
+
+func registerAuditMetrics() {
+	for _, p := range auditGauges {
+		prometheus.MustRegister(p)
+	}
+}
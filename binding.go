@@ -0,0 +1,9 @@
+package main
+
+type binding struct {
+	Source          string `json:"source"`
+	Vhost           string `json:"vhost"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"`
+	RoutingKey      string `json:"routing_key"`
+}
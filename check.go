@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the exit codes of nagios compatible check plugins
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatusNames = map[int]string{
+	checkOK:       "OK",
+	checkWarning:  "WARNING",
+	checkCritical: "CRITICAL",
+	checkUnknown:  "UNKNOWN",
+}
+
+// checkResult collects the problems and the performance data of a check run
+type checkResult struct {
+	status   int
+	problems []string
+	perfdata []string
+}
+
+// the exit codes don't follow the severity, a failed check is worse than one that could not tell
+var checkSeverity = map[int]int{
+	checkOK:       0,
+	checkWarning:  1,
+	checkUnknown:  2,
+	checkCritical: 3,
+}
+
+func (r *checkResult) raise(status int, format string, args ...interface{}) {
+	if checkSeverity[status] > checkSeverity[r.status] {
+		r.status = status
+	}
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+// perf adds a performance data entry, nagios wants the label quoted when it holds anything unusual
+func (r *checkResult) perf(label string, value float64, unit string, warning, critical float64) {
+	thresholds := ";;"
+	if warning > 0 || critical > 0 {
+		thresholds = fmt.Sprintf(";%s;%s", checkThreshold(warning), checkThreshold(critical))
+	}
+	r.perfdata = append(r.perfdata, fmt.Sprintf("'%s'=%g%s%s", label, value, unit, thresholds))
+}
+
+func checkThreshold(value float64) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g", value)
+}
+
+// evaluates a value against the warning and critical thresholds, a threshold of 0 is disabled
+func (r *checkResult) threshold(value, warning, critical float64, format string, args ...interface{}) {
+	switch {
+	case critical > 0 && value >= critical:
+		r.raise(checkCritical, format+fmt.Sprintf(" (>= %g)", critical), args...)
+	case warning > 0 && value >= warning:
+		r.raise(checkWarning, format+fmt.Sprintf(" (>= %g)", warning), args...)
+	}
+}
+
+// runCheck runs a single scan of the cluster, prints the outcome as a nagios check plugin and returns the exit code
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	address := flags.String("host", host, "the host of the cluster, defaults to HOST")
+	user := flags.String("username", username, "the user for the api and amqp, defaults to USERNAME")
+	pass := flags.String("password", password, "the password for the api and amqp, defaults to PASSWORD")
+	name := flags.String("cluster-name", clusterName, "the name of the cluster, defaults to CLUSTER_NAME")
+	port := flags.Int("api-port", apiPort, "the port of the management api")
+	core := flags.Bool("amqp", true, "critical when the cluster does not accept amqp connections")
+	queueWarning := flags.Int("queue-warning", 0, "warn when a queue holds at least this many messages")
+	queueCritical := flags.Int("queue-critical", 0, "critical when a queue holds at least this many messages")
+	fdWarning := flags.Float64("fd-warning", 0, "warn when a node uses at least this percentage of its file descriptors")
+	fdCritical := flags.Float64("fd-critical", 0, "critical when a node uses at least this percentage of its file descriptors")
+	partitions := flags.Bool("partitions", true, "critical when any node reports a network partition")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println("RABBITMQ UNKNOWN -", err)
+		return checkUnknown
+	}
+
+	apiPort = *port
+	localCluster := &cluster{
+		Address:     *address,
+		Username:    *user,
+		Password:    *pass,
+		ClusterName: *name,
+	}
+	// the scan logs to stderr, only the plugin output ends up on stdout
+	localCluster.scan()
+
+	result := localCluster.check(float64(*queueWarning), float64(*queueCritical), *fdWarning, *fdCritical, *partitions, *core)
+
+	summary := "everything looks fine"
+	if len(result.problems) > 0 {
+		summary = strings.Join(result.problems, ", ")
+	}
+	if len(result.perfdata) > 0 {
+		summary += " | " + strings.Join(result.perfdata, " ")
+	}
+	fmt.Printf("RABBITMQ %s - %s\n", checkStatusNames[result.status], summary)
+	return result.status
+}
+
+// check evaluates the thresholds against the last scan of the cluster
+func (c *cluster) check(queueWarning, queueCritical, fdWarning, fdCritical float64, partitions, core bool) *checkResult {
+	result := &checkResult{}
+
+	if c.apiReachable == 0 {
+		result.raise(checkCritical, "api unreachable")
+	} else {
+		result.perf("api_latency", time.Duration(c.apiLatency).Seconds(), "s", 0, 0)
+	}
+	switch {
+	case !core:
+	case c.coreReachable == 0:
+		result.raise(checkCritical, "amqp unreachable")
+	default:
+		result.perf("core_latency", time.Duration(c.coreLatency).Seconds(), "s", 0, 0)
+	}
+	if c.apiReachable == 0 {
+		return result
+	}
+	// without the nodes or the queues the thresholds can't be told apart from an empty cluster
+	for _, resource := range []string{"nodes", "queues"} {
+		if c.unreadable[resource] {
+			result.raise(checkUnknown, "could not read the %s", resource)
+		}
+	}
+
+	nodeNames := make([]string, 0, len(c.Nodes))
+	for name := range c.Nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+	for _, name := range nodeNames {
+		node := c.Nodes[name]
+		if node.FdMax > 0 {
+			usage := float64(node.FdCurrent) * 100 / float64(node.FdMax)
+			result.threshold(usage, fdWarning, fdCritical, "%s uses %.1f%% of its file descriptors", node.Name, usage)
+			result.perf("fd_usage_"+node.Name, usage, "%", fdWarning, fdCritical)
+		}
+		if partitions && len(node.Partitions) > 0 {
+			result.raise(checkCritical, "%s is partitioned from %s", node.Name, strings.Join(node.Partitions, ","))
+		}
+	}
+
+	deepest := 0
+	queueKeys := make([]string, 0, len(c.Queues))
+	for key := range c.Queues {
+		queueKeys = append(queueKeys, key)
+	}
+	sort.Strings(queueKeys)
+	for _, key := range queueKeys {
+		queue := c.Queues[key]
+		if queue.Messages > deepest {
+			deepest = queue.Messages
+		}
+		result.threshold(float64(queue.Messages), queueWarning, queueCritical, "%s in %s holds %d messages", queue.Name, queue.Vhost, queue.Messages)
+	}
+	result.perf("queues", float64(len(c.Queues)), "", 0, 0)
+	result.perf("max_queue_messages", float64(deepest), "", queueWarning, queueCritical)
+
+	return result
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newFakeBroker serves the management api of a cluster with a single node and two queues, the paths given in
+// failing answer with an error
+func newFakeBroker(t *testing.T, failing ...string) *url.URL {
+	responses := map[string]string{
+		"/api/overview": `{"rabbitmq_version": "3.8.9", "cluster_name": "rabbit@a"}`,
+		"/api/nodes":    `[{"name": "rabbit@a", "fd_used": 100, "fd_total": 1000, "partitions": []}]`,
+		"/api/queues": `[{"name": "orders", "vhost": "/", "node": "rabbit@a", "messages": 50},
+			{"name": "billing", "vhost": "/", "node": "rabbit@a", "messages": 5}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range failing {
+			if r.URL.Path == path {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		if body, exists := responses[r.URL.Path]; exists {
+			io.WriteString(w, body)
+			return
+		}
+		io.WriteString(w, "[]")
+	}))
+	t.Cleanup(server.Close)
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// captureCheck runs the check plugin and returns its exit code and what it printed
+func captureCheck(t *testing.T, args []string) (int, string) {
+	defer func(port int) { apiPort = port }(apiPort)
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	os.Stdout = writer
+
+	code := runCheck(args)
+	writer.Close()
+	var out bytes.Buffer
+	io.Copy(&out, reader)
+	return code, out.String()
+}
+
+func TestRunCheckAgainstABroker(t *testing.T) {
+	tests := []struct {
+		description string
+		failing     []string
+		flags       []string
+		code        int
+		output      []string // parts the output has to contain
+	}{
+		{
+			"nothing crosses a threshold", nil,
+			[]string{"-queue-warning", "1000", "-queue-critical", "10000", "-fd-warning", "80", "-fd-critical", "90"},
+			checkOK,
+			[]string{"RABBITMQ OK - everything looks fine | 'api_latency'=", "'fd_usage_rabbit@a'=10%;80;90", "'queues'=2;;", "'max_queue_messages'=50;1000;10000"},
+		},
+		{
+			"a queue crosses the warning threshold", nil,
+			[]string{"-queue-warning", "40"},
+			checkWarning,
+			[]string{"RABBITMQ WARNING - orders in / holds 50 messages (>= 40) |", "'max_queue_messages'=50;40;"},
+		},
+		{
+			"a node crosses the critical threshold", nil,
+			[]string{"-fd-warning", "5", "-fd-critical", "10"},
+			checkCritical,
+			[]string{"RABBITMQ CRITICAL - rabbit@a uses 10.0% of its file descriptors (>= 10) |", "'fd_usage_rabbit@a'=10%;5;10"},
+		},
+		{
+			"the nodes can not be read", []string{"/api/nodes"},
+			nil,
+			checkUnknown,
+			[]string{"RABBITMQ UNKNOWN - could not read the nodes |"},
+		},
+		{
+			"a critical queue while the nodes can not be read", []string{"/api/nodes"},
+			[]string{"-queue-critical", "10"},
+			checkCritical,
+			[]string{"RABBITMQ CRITICAL - could not read the nodes, orders in / holds 50 messages (>= 10) |"},
+		},
+		{
+			"the api fails", []string{"/api/overview"},
+			nil,
+			checkCritical,
+			[]string{"RABBITMQ CRITICAL - api unreachable"},
+		},
+	}
+
+	for i, test := range tests {
+		broker := newFakeBroker(t, test.failing...)
+		args := append([]string{"-host", broker.Hostname(), "-api-port", broker.Port(), "-amqp=false", "-cluster-name", "check-" + strconv.Itoa(i)}, test.flags...)
+		code, output := captureCheck(t, args)
+		if code != test.code {
+			t.Errorf("%s: expected exit code %d, got %d with %q", test.description, test.code, code, output)
+		}
+		for _, part := range test.output {
+			if !strings.Contains(output, part) {
+				t.Errorf("%s: expected %q in the output, got %q", test.description, part, output)
+			}
+		}
+	}
+}
+
+func TestRunCheckRejectsUnknownFlags(t *testing.T) {
+	code, output := captureCheck(t, []string{"-queue-warnings", "10"})
+	if code != checkUnknown || !strings.HasPrefix(output, "RABBITMQ UNKNOWN -") {
+		t.Errorf("expected an unknown flag to be UNKNOWN, got %d with %q", code, output)
+	}
+}
+
+func TestCheckResultKeepsTheWorstStatus(t *testing.T) {
+	tests := []struct {
+		raised []int
+		status int
+	}{
+		{nil, checkOK},
+		{[]int{checkWarning}, checkWarning},
+		{[]int{checkWarning, checkUnknown}, checkUnknown},
+		{[]int{checkUnknown, checkWarning}, checkUnknown},
+		{[]int{checkCritical, checkUnknown}, checkCritical},
+		{[]int{checkUnknown, checkCritical, checkWarning}, checkCritical},
+	}
+
+	for _, test := range tests {
+		result := &checkResult{}
+		for _, status := range test.raised {
+			result.raise(status, "raised %s", checkStatusNames[status])
+		}
+		if result.status != test.status {
+			t.Errorf("raising %v: expected %s, got %s", test.raised, checkStatusNames[test.status], checkStatusNames[result.status])
+		}
+		if len(result.problems) != len(test.raised) {
+			t.Errorf("raising %v: expected every problem to be kept, got %v", test.raised, result.problems)
+		}
+	}
+}
@@ -19,9 +19,20 @@ type cluster struct {
 
 	Nodes           map[string]*node
 	Vhosts          map[string]*vhost
-	Queues          map[string]*queue
+	Queues          map[string]*queue // keyed by queueKey
 	Shovels         map[string]*shovel
 	FederationLinks map[string]*federationLink
+	Upstreams       *federationUpstreams
+	Overview        *overview
+	Policies        map[string]*policy
+	Exchanges       map[string]*exchange
+	Audit           *audit           // only touched by the audit loop
+	Anomalies       *anomalyDetector // only set when the anomaly detection is enabled
+	Filters         filters          // the objects to leave out of the metrics
+	GroupRules      []*queueGroupRule
+	QueueGroups     map[string]*queueGroup
+
+	Imbalance map[string]float64 // the imbalance score of the nodes, per dimension
 
 	ClusterName string // the current name of the cluster
 
@@ -33,6 +44,7 @@ type cluster struct {
 	coreReachableGauge *prometheus.GaugeVec // the core reachability gauge
 	coreLatency        int                  // indicates what is the observed latency for connecting to core
 	coreLatencyGauge   *prometheus.GaugeVec // the core latency gauge
+	unreadable         map[string]bool      // the api resources the last scan failed to read
 }
 
 // runs all the scans for the api and the core
@@ -43,6 +55,7 @@ func (c *cluster) scan() {
 	c.apiLatency = 0
 	c.coreReachable = 0
 	c.coreLatency = 0
+	c.unreadable = map[string]bool{}
 
 	if c.Nodes == nil {
 		c.Nodes = map[string]*node{}
@@ -59,18 +72,52 @@ func (c *cluster) scan() {
 	if c.FederationLinks == nil {
 		c.FederationLinks = map[string]*federationLink{}
 	}
+	if c.Policies == nil {
+		c.Policies = map[string]*policy{}
+	}
+	if c.Exchanges == nil {
+		c.Exchanges = map[string]*exchange{}
+	}
+	if c.Upstreams == nil {
+		c.Upstreams = &federationUpstreams{}
+	}
+	if c.Overview == nil {
+		c.Overview = &overview{}
+	}
 
 	// populate the fields
 	c.connect()
 	c.apiConnect()
 	c.nodes()
 	c.vhosts()
+	c.vhostLimits()
+	c.connections()
 	c.queues()
+	c.policies()
+	c.exchanges()
+	c.bindings()
 	c.shovels()
 	c.federationLinks()
+	c.federationUpstreams()
+
+	// the rates compare against the history, so they are computed before this scan is added to it
+	now := time.Now()
+	c.queueRates(now)
+	c.queueStatuses(now)
+	c.queueActivity(now)
+	c.capacityForecast(now)
+	if anomalyDetection {
+		c.detectAnomalies(now)
+	}
 
 	// attach all the metrics to the prometheus instance
 	c.updateMetrics()
+	metricLabels.update(c)
+
+	// keep a copy of the state for the json api and the history
+	s := c.snapshot(now)
+	snapshots.store(s)
+	scanHistory.add(s)
 }
 
 func (c *cluster) updateMetrics() {
@@ -78,6 +125,11 @@ func (c *cluster) updateMetrics() {
 	clusterGauges["api_latency"].With(prometheus.Labels{"cluster_name": c.ClusterName, "environment": environment}).Set(float64(c.apiLatency))
 	clusterGauges["core_reachable"].With(prometheus.Labels{"cluster_name": c.ClusterName, "environment": environment}).Set(float64(c.coreReachable))
 	clusterGauges["core_latency"].With(prometheus.Labels{"cluster_name": c.ClusterName, "environment": environment}).Set(float64(c.coreLatency))
+	c.updateBalanceMetrics()
+
+	if c.apiReachable == 1 {
+		c.Overview.updateMetrics()
+	}
 
 	for _, node := range c.Nodes {
 		node.updateMetrics()
@@ -88,7 +140,17 @@ func (c *cluster) updateMetrics() {
 	}
 
 	for _, queue := range c.Queues {
-		queue.updateMetrics()
+		if !queue.grouped {
+			queue.updateMetrics()
+		}
+	}
+
+	for _, policy := range c.Policies {
+		policy.updateMetrics()
+	}
+
+	for _, exchange := range c.Exchanges {
+		exchange.updateMetrics()
 	}
 
 	for _, shovel := range c.Shovels {
@@ -98,12 +160,27 @@ func (c *cluster) updateMetrics() {
 	for _, fl := range c.FederationLinks {
 		fl.updateMetrics()
 	}
+
+	c.Upstreams.updateMetrics()
+	for _, group := range c.QueueGroups {
+		group.updateMetrics()
+	}
+	if c.Anomalies != nil {
+		c.Anomalies.updateMetrics()
+	}
 }
 
+// the port of the management api, the check plugin can point it elsewhere
+var apiPort = 15672
+
 // connects to the cluster using the rabbitmq connector
 func (c *cluster) connect() {
 	beforeConn := time.Now().UnixNano()
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:5672/%%2F", c.Username, c.Password, c.Address))
+	conn, err := amqp.DialConfig(fmt.Sprintf("amqp://%s:%s@%s:5672/%%2F", c.Username, c.Password, c.Address), amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Locale:    "en_US",
+		Dial:      amqp.DefaultDial(apiTimeout),
+	})
 	afterConn := time.Now().UnixNano()
 	if err != nil {
 		return
@@ -114,14 +191,14 @@ func (c *cluster) connect() {
 	return
 }
 
-// connects to the cluster api using web calls
+// connects to the cluster api using web calls and reads the overview returned by it
 func (c *cluster) apiConnect() {
 	beforeConn := time.Now().UnixNano()
 	tr := &http.Transport{
 		DisableCompression: true,
 	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/overview", c.Address), nil)
+	client := &http.Client{Transport: tr, Timeout: apiTimeout}
+	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/api/overview", c.Address, apiPort), nil)
 	if err != nil {
 		return
 	}
@@ -133,44 +210,54 @@ func (c *cluster) apiConnect() {
 	}
 	defer response.Body.Close()
 	defer client.CloseIdleConnections()
+	// a rejected login answers too, but the api is of no use to us
+	if response.StatusCode != http.StatusOK {
+		return
+	}
 
 	c.apiLatency = int(afterConn - beforeConn)
 	c.apiReachable = 1
-}
 
-// retrieves node information using the node api
-func (c *cluster) nodes() {
-	tr := &http.Transport{
-		DisableCompression: true,
-	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/nodes", c.Address), nil)
-	request.SetBasicAuth(c.Username, c.Password)
-	response, err := client.Do(request)
+	contents, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return
 	}
-	defer response.Body.Close()
-	defer client.CloseIdleConnections()
-	contents, err := ioutil.ReadAll(response.Body)
+
+	localOverview := &overview{}
+	err = json.Unmarshal(contents, localOverview)
 	if err != nil {
 		return
 	}
+	c.Overview.ClusterName = c.ClusterName
+	c.Overview.update(localOverview)
+}
 
+// retrieves node information using the node api
+func (c *cluster) nodes() {
 	nodes := []*node{}
-	err = json.Unmarshal(contents, &nodes)
-	if err != nil {
+	if err := c.apiGet("/api/nodes", &nodes); err != nil {
+		c.unreadable["nodes"] = true
 		return
 	}
 
+	seen := map[string]bool{}
 	for _, node := range nodes {
 		node.ClusterName = c.ClusterName
+		seen[node.Name] = true
 		if _, exists := c.Nodes[node.Name]; exists == true {
 			c.Nodes[node.Name].update(node)
 		} else {
 			c.Nodes[node.Name] = node
 		}
 	}
+
+	// a node that left the cluster would otherwise count as an empty node in the balance
+	for name, node := range c.Nodes {
+		if !seen[name] {
+			node.deleteMetrics()
+			delete(c.Nodes, name)
+		}
+	}
 }
 
 // retrieves all the current vhosts using the vhosts api
@@ -178,8 +265,8 @@ func (c *cluster) vhosts() {
 	tr := &http.Transport{
 		DisableCompression: true,
 	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/vhosts", c.Address), nil)
+	client := &http.Client{Transport: tr, Timeout: apiTimeout}
+	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/api/vhosts", c.Address, apiPort), nil)
 	request.SetBasicAuth(c.Username, c.Password)
 	response, err := client.Do(request)
 	if err != nil {
@@ -197,116 +284,408 @@ func (c *cluster) vhosts() {
 	if err != nil {
 		return
 	}
+
+	names := []string{}
+	for _, vhost := range vhosts {
+		if c.Filters.Vhosts.allows(vhost.Name) {
+			names = append(names, vhost.Name)
+		} else {
+			c.dropped("vhosts", "filtered", 1)
+		}
+	}
+	kept := c.limit("vhosts", names)
+
 	for _, vhost := range vhosts {
+		if !kept[vhost.Name] {
+			continue
+		}
 		vhost.ClusterName = c.ClusterName
 		if _, exists := c.Vhosts[vhost.Name]; exists == true {
 			c.Vhosts[vhost.Name].update(vhost)
 		} else {
+			vhost.MaxConnections = -1
+			vhost.MaxQueues = -1
 			c.Vhosts[vhost.Name] = vhost
 		}
 	}
-}
 
-func (c *cluster) queues() {
-	tr := &http.Transport{
-		DisableCompression: true,
+	for name, vhost := range c.Vhosts {
+		if !kept[name] {
+			vhost.deleteMetrics()
+			delete(c.Vhosts, name)
+		}
 	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/queues", c.Address), nil)
-	request.SetBasicAuth(c.Username, c.Password)
-	response, err := client.Do(request)
-	if err != nil {
+}
+
+// retrieves the vhost limits and attaches them to the known vhosts
+func (c *cluster) vhostLimits() {
+	limits := []*vhostLimit{}
+	if err := c.apiGet("/api/vhost-limits", &limits); err != nil {
 		return
 	}
-	defer response.Body.Close()
-	defer client.CloseIdleConnections()
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
+
+	// vhosts without an entry have no limits
+	for _, vhost := range c.Vhosts {
+		vhost.MaxConnections = -1
+		vhost.MaxQueues = -1
+	}
+	for _, limit := range limits {
+		vhost, exists := c.Vhosts[limit.Vhost]
+		if !exists {
+			continue
+		}
+		if value, exists := limit.Value["max-connections"]; exists {
+			vhost.MaxConnections = value
+		}
+		if value, exists := limit.Value["max-queues"]; exists {
+			vhost.MaxQueues = value
+		}
+	}
+}
+
+// retrieves the open connections and counts them per vhost
+func (c *cluster) connections() {
+	connections := []*connection{}
+	if err := c.apiGet("/api/connections?columns=name,vhost,user,node", &connections); err != nil {
 		return
 	}
 
+	for _, vhost := range c.Vhosts {
+		vhost.Connections = 0
+		vhost.TotalConnections = 0
+	}
+	for _, connection := range connections {
+		connection.ClusterName = c.ClusterName
+		vhost, exists := c.Vhosts[connection.Vhost]
+		if exists {
+			// the limit applies to every connection, whatever the filters leave out
+			vhost.TotalConnections++
+		}
+		if !c.Filters.Connections.allows(connection.Name) {
+			c.dropped("connections", "filtered", 1)
+			continue
+		}
+		if exists {
+			vhost.Connections++
+		}
+	}
+}
+
+func (c *cluster) queues() {
 	queues := []*queue{}
-	err = json.Unmarshal(contents, &queues)
-	if err != nil {
+	if err := c.apiGet("/api/queues", &queues); err != nil {
+		c.unreadable["queues"] = true
 		return
 	}
 
+	for _, vhost := range c.Vhosts {
+		vhost.Queues = 0
+	}
 	for _, queue := range queues {
+		if vhost, exists := c.Vhosts[queue.Vhost]; exists {
+			vhost.Queues++
+		}
+	}
+	c.distribution(queues)
+
+	// the totals above cover every queue, only the per queue metrics are filtered
+	allowed := []*queue{}
+	for _, queue := range queues {
+		if c.Filters.Vhosts.allows(queue.Vhost) && c.Filters.Queues.allows(queue.Name) {
+			allowed = append(allowed, queue)
+		} else {
+			c.dropped("queues", "filtered", 1)
+		}
+	}
+	c.queueGroups(allowed)
+
+	// the queues of a group only get their own metrics when the rule asks for it, they are still tracked
+	keys := []string{}
+	grouped := map[string]bool{}
+	for _, queue := range allowed {
+		if _, keep, _ := c.groupOf(queue); keep {
+			keys = append(keys, queueKey(queue.Vhost, queue.Name))
+		} else {
+			grouped[queueKey(queue.Vhost, queue.Name)] = true
+		}
+	}
+	kept := c.limit("queues", keys)
+
+	// same named queues of different vhosts are different queues
+	for _, queue := range queues {
+		key := queueKey(queue.Vhost, queue.Name)
+		if !kept[key] && !grouped[key] {
+			continue
+		}
 		queue.ClusterName = c.ClusterName
-		if _, exists := c.Queues[queue.Name]; exists == true {
-			c.Queues[queue.Name].update(queue)
+		if _, exists := c.Queues[key]; exists == true {
+			c.Queues[key].update(queue)
 		} else {
-			c.Queues[queue.Name] = queue
+			c.Queues[key] = queue
+		}
+		if grouped[key] && !c.Queues[key].grouped {
+			c.Queues[key].deleteMetrics()
+		}
+		c.Queues[key].grouped = grouped[key]
+	}
+
+	for key, queue := range c.Queues {
+		if !kept[key] && !grouped[key] {
+			queue.deleteMetrics()
+			delete(c.Queues, key)
 		}
 	}
 }
 
-func (c *cluster) shovels() {
-	tr := &http.Transport{
-		DisableCompression: true,
+// retrieves the policies and operator policies and counts the queues each of them applies to
+func (c *cluster) policies() {
+	policies := []*policy{}
+	if err := c.apiGet("/api/policies", &policies); err != nil {
+		return
 	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/shovels", c.Address), nil)
-	request.SetBasicAuth(c.Username, c.Password)
-	response, err := client.Do(request)
-	if err != nil {
+	for _, policy := range policies {
+		policy.Kind = "policy"
+	}
+	// older brokers and users without the policymaker tag can't read the operator policies, the policies still count
+	operatorPolicies := []*policy{}
+	operatorPoliciesRead := true
+	if err := c.apiGet("/api/operator-policies", &operatorPolicies); err != nil {
+		log.Printf("Reading the operator policies of %s failed, keeping the last known ones: %s", c.ClusterName, err)
+		operatorPoliciesRead = false
+	}
+	for _, policy := range operatorPolicies {
+		policy.Kind = "operator_policy"
+	}
+
+	seen := map[string]bool{}
+	if !operatorPoliciesRead {
+		for key, policy := range c.Policies {
+			if policy.Kind == "operator_policy" {
+				seen[key] = true
+				policy.Queues = 0
+			}
+		}
+	}
+	for _, policy := range append(policies, operatorPolicies...) {
+		if !c.Filters.Vhosts.allows(policy.Vhost) {
+			c.dropped("policies", "filtered", 1)
+			continue
+		}
+		policy.ClusterName = c.ClusterName
+		key := policyKey(policy.Kind, policy.Vhost, policy.Name)
+		seen[key] = true
+		if _, exists := c.Policies[key]; exists == true {
+			c.Policies[key].update(policy)
+		} else {
+			c.Policies[key] = policy
+		}
+		c.Policies[key].Queues = 0
+	}
+
+	// policies are removed often enough that their series should not linger around
+	for key, policy := range c.Policies {
+		if !seen[key] {
+			policy.deleteMetrics()
+			delete(c.Policies, key)
+		}
+	}
+
+	for _, queue := range c.Queues {
+		if policy, exists := c.Policies[policyKey("policy", queue.Vhost, queue.Policy)]; exists {
+			policy.Queues++
+		}
+		if policy, exists := c.Policies[policyKey("operator_policy", queue.Vhost, queue.OperatorPolicy)]; exists {
+			policy.Queues++
+		}
+	}
+}
+
+// retrieves all the exchanges using the exchanges api
+func (c *cluster) exchanges() {
+	exchanges := []*exchange{}
+	if err := c.apiGet("/api/exchanges", &exchanges); err != nil {
 		return
 	}
-	defer response.Body.Close()
-	defer client.CloseIdleConnections()
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
+
+	keys := []string{}
+	for _, exchange := range exchanges {
+		if c.Filters.Vhosts.allows(exchange.Vhost) && c.Filters.Exchanges.allows(exchange.Name) {
+			keys = append(keys, exchangeKey(exchange.Vhost, exchange.Name))
+		} else {
+			c.dropped("exchanges", "filtered", 1)
+		}
+	}
+	seen := c.limit("exchanges", keys)
+
+	for _, exchange := range exchanges {
+		key := exchangeKey(exchange.Vhost, exchange.Name)
+		if !seen[key] {
+			continue
+		}
+		exchange.ClusterName = c.ClusterName
+		if _, exists := c.Exchanges[key]; exists == true {
+			c.Exchanges[key].update(exchange)
+		} else {
+			c.Exchanges[key] = exchange
+		}
+	}
+
+	for key, exchange := range c.Exchanges {
+		if !seen[key] {
+			exchange.deleteMetrics()
+			delete(c.Exchanges, key)
+		}
+	}
+}
+
+// retrieves all the bindings and counts them per source exchange and per destination queue
+func (c *cluster) bindings() {
+	// without the bindings every queue would look unbound, their series are left out until the next scan instead
+	bindings := []*binding{}
+	read := c.apiGet("/api/bindings", &bindings) == nil
+	for _, exchange := range c.Exchanges {
+		exchange.Bindings = 0
+		exchange.bindingsRead = read
+	}
+	for _, queue := range c.Queues {
+		queue.Bindings = 0
+		queue.bindingsRead = read
+	}
+	if !read {
+		c.unreadable["bindings"] = true
 		return
 	}
+	for _, binding := range bindings {
+		if exchange, exists := c.Exchanges[exchangeKey(binding.Vhost, binding.Source)]; exists {
+			exchange.Bindings++
+		}
+		// every queue is bound to the default exchange, that binding does not route anything on purpose
+		if binding.DestinationType != "queue" || binding.Source == "" {
+			continue
+		}
+		if queue, exists := c.Queues[queueKey(binding.Vhost, binding.Destination)]; exists {
+			queue.Bindings++
+		}
+	}
+}
 
+// retrieves the status of all the static and dynamic shovels
+func (c *cluster) shovels() {
 	shovels := []*shovel{}
-	err = json.Unmarshal(contents, &shovels)
-	if err != nil {
+	if err := c.apiGet("/api/shovels", &shovels); err != nil {
 		return
 	}
 
+	seen := map[string]bool{}
 	for _, shovel := range shovels {
+		if !c.Filters.Vhosts.allows(shovel.Vhost) {
+			c.dropped("shovels", "filtered", 1)
+			continue
+		}
 		shovel.ClusterName = c.ClusterName
-		if _, exists := c.Shovels[shovel.Name]; exists == true {
-			c.Shovels[shovel.Name].update(shovel)
+		key := shovelKey(shovel.Vhost, shovel.Name)
+		seen[key] = true
+		if _, exists := c.Shovels[key]; exists == true {
+			c.Shovels[key].update(shovel)
 		} else {
-			c.Shovels[shovel.Name] = shovel
+			c.Shovels[key] = shovel
+		}
+	}
+
+	for key, shovel := range c.Shovels {
+		if !seen[key] {
+			shovel.deleteMetrics()
+			delete(c.Shovels, key)
 		}
 	}
 }
 
+// retrieves all the federation links, for both federated exchanges and queues
 func (c *cluster) federationLinks() {
+	allLinks := []*federationLink{}
+	if err := c.apiGet("/api/federation-links", &allLinks); err != nil {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, link := range allLinks {
+		if !c.Filters.Vhosts.allows(link.Vhost) {
+			c.dropped("federation_links", "filtered", 1)
+			continue
+		}
+		link.ClusterName = c.ClusterName
+		key := federationLinkKey(link.Vhost, link.Name, link.Type, link.resource())
+		seen[key] = true
+		if _, exists := c.FederationLinks[key]; exists == true {
+			c.FederationLinks[key].update(link)
+		} else {
+			c.FederationLinks[key] = link
+		}
+	}
+
+	for key, link := range c.FederationLinks {
+		if !seen[key] {
+			link.deleteMetrics()
+			delete(c.FederationLinks, key)
+		}
+	}
+}
+
+// retrieves the configured federation upstreams and upstream sets and reconciles them with the live links
+func (c *cluster) federationUpstreams() {
+	localUpstreams := &federationUpstreams{}
+	if err := c.apiGet("/api/parameters/federation-upstream", &localUpstreams.Upstreams); err != nil {
+		return
+	}
+	if err := c.apiGet("/api/parameters/federation-upstream-set", &localUpstreams.Sets); err != nil {
+		return
+	}
+
+	upstreams := []*federationUpstream{}
+	for _, upstream := range localUpstreams.Upstreams {
+		if c.Filters.Vhosts.allows(upstream.Vhost) {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	sets := []*federationUpstreamSet{}
+	for _, set := range localUpstreams.Sets {
+		if c.Filters.Vhosts.allows(set.Vhost) {
+			sets = append(sets, set)
+		}
+	}
+	localUpstreams.Upstreams, localUpstreams.Sets = upstreams, sets
+
+	c.Upstreams.ClusterName = c.ClusterName
+	c.Upstreams.update(localUpstreams)
+	c.Upstreams.reconcile(c.Nodes, c.FederationLinks, c.Policies)
+}
+
+// performs a GET on the given management api path and decodes the json response into result
+func (c *cluster) apiGet(path string, result interface{}) error {
 	tr := &http.Transport{
 		DisableCompression: true,
 	}
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:15672/api/federation-links", c.Address), nil)
+	client := &http.Client{Transport: tr, Timeout: apiTimeout}
+	defer client.CloseIdleConnections()
+	request, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d%s", c.Address, apiPort, path), nil)
+	if err != nil {
+		return err
+	}
 	request.SetBasicAuth(c.Username, c.Password)
 	response, err := client.Do(request)
 	if err != nil {
-		return
+		return err
 	}
 	defer response.Body.Close()
-	defer client.CloseIdleConnections()
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d for %s", response.StatusCode, path)
 	}
-
-	allLinks := []*federationLink{}
-	err = json.Unmarshal(contents, &allLinks)
+	contents, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return
+		return err
 	}
 
-	for _, link := range allLinks {
-		link.ClusterName = c.ClusterName
-		if _, exists := c.FederationLinks[link.Name]; exists == true {
-			c.FederationLinks[link.Name].update(link)
-		} else {
-			c.FederationLinks[link.Name] = link
-		}
-	}
+	return json.Unmarshal(contents, result)
 }
 
 var clusterGauges = map[string]*prometheus.GaugeVec{
@@ -334,6 +713,12 @@ var clusterGauges = map[string]*prometheus.GaugeVec{
 			Help: "The latency of connecting to the local server using the amqp protocol",
 		},
 		[]string{"cluster_name", "environment"}),
+	"imbalance": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_cluster_imbalance",
+			Help: "How much more of the queues, quorum leaders, messages or queue memory the busiest node holds than the average node, 0 when balanced",
+		},
+		[]string{"cluster_name", "environment", "dimension"}),
 }
 
 func registerClusterMetrics() {
@@ -0,0 +1,9 @@
+package main
+
+type connection struct {
+	Name        string `json:"name"`
+	Vhost       string `json:"vhost"`
+	User        string `json:"user"`
+	Node        string `json:"node"`
+	ClusterName string
+}
@@ -0,0 +1,101 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type exchange struct {
+	Name         string               `json:"name"`
+	Vhost        string               `json:"vhost"`
+	Type         string               `json:"type"`
+	Durable      bool                 `json:"durable"`
+	Internal     bool                 `json:"internal"`
+	MessageStats exchangeMessageStats `json:"message_stats"`
+	ClusterName  string               `json:"-"`
+
+	Bindings int `json:"-"` // the number of bindings having this exchange as source, handed out through exchangeOutput
+
+	bindingsRead bool // the bindings were read in the last scan, so Bindings can be trusted
+}
+
+type exchangeMessageStats struct {
+	PublishIn  messageRate `json:"publish_in_details"`
+	PublishOut messageRate `json:"publish_out_details"`
+}
+
+// exchangeKey identifies an exchange inside a cluster
+func exchangeKey(vhost, name string) string {
+	return vhost + "/" + name
+}
+
+func (e *exchange) update(localExchange *exchange) {
+	e.Name = localExchange.Name
+	e.Vhost = localExchange.Vhost
+	e.Type = localExchange.Type
+	e.Durable = localExchange.Durable
+	e.Internal = localExchange.Internal
+	e.MessageStats = localExchange.MessageStats
+}
+
+func (e *exchange) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster_name": e.ClusterName,
+		"environment":  environment,
+		"vhost":        e.Vhost,
+		"exchange":     e.Name,
+	}
+}
+
+func (e *exchange) updateMetrics() {
+	exchangeGauges["publish_in_rate"].With(e.labels()).Set(e.MessageStats.PublishIn.Rate)
+	exchangeGauges["publish_out_rate"].With(e.labels()).Set(e.MessageStats.PublishOut.Rate)
+	if !e.bindingsRead {
+		exchangeGauges["bindings"].Delete(e.labels())
+		exchangeGauges["unbound_receiving"].Delete(e.labels())
+		return
+	}
+	exchangeGauges["bindings"].With(e.labels()).Set(float64(e.Bindings))
+	if e.Bindings == 0 && e.MessageStats.PublishIn.Rate > 0 {
+		exchangeGauges["unbound_receiving"].With(e.labels()).Set(1)
+	} else {
+		exchangeGauges["unbound_receiving"].With(e.labels()).Set(0)
+	}
+}
+
+// removes all the series of an exchange that does not exist anymore
+func (e *exchange) deleteMetrics() {
+	for _, gauge := range exchangeGauges {
+		gauge.Delete(e.labels())
+	}
+}
+
+var exchangeGauges = map[string]*prometheus.GaugeVec{
+	"bindings": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_exchange_bindings",
+			Help: "Number of bindings having the exchange as source",
+		},
+		[]string{"cluster_name", "environment", "vhost", "exchange"}),
+	"publish_in_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_exchange_publish_in_rate",
+			Help: "Rate of messages published into the exchange per second",
+		},
+		[]string{"cluster_name", "environment", "vhost", "exchange"}),
+	"publish_out_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_exchange_publish_out_rate",
+			Help: "Rate of messages routed out of the exchange per second",
+		},
+		[]string{"cluster_name", "environment", "vhost", "exchange"}),
+	"unbound_receiving": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_exchange_unbound_receiving",
+			Help: "Indicates if the exchange has no bindings but still receives publishes",
+		},
+		[]string{"cluster_name", "environment", "vhost", "exchange"}),
+}
+
+func registerExchangeMetrics() {
+	for _, p := range exchangeGauges {
+		prometheus.MustRegister(p)
+	}
+}
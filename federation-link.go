@@ -5,121 +5,219 @@ import (
 )
 
 type federationLink struct {
-	Status       string                  `json:"status"`
-	Vhost        string                  `json:"vhost"`
-	Name         string                  `json:"upstream"`
-	Node         string                  `json:"node"`
-	LocalChannel *federationLocalChannel `json:"local_channel"`
-	ClusterName  string
+	Status           string                  `json:"status"`
+	Vhost            string                  `json:"vhost"`
+	Name             string                  `json:"upstream"`
+	Node             string                  `json:"node"`
+	Type             string                  `json:"type"` // exchange or queue
+	Exchange         string                  `json:"exchange"`
+	Queue            string                  `json:"queue"`
+	UpstreamExchange string                  `json:"upstream_exchange"`
+	UpstreamQueue    string                  `json:"upstream_queue"`
+	URI              string                  `json:"uri"`
+	Error            string                  `json:"error"`
+	LocalChannel     *federationLocalChannel `json:"local_channel"` // missing until the link is fully started
+	ClusterName      string                  `json:"-"`
+
+	lastLabels  prometheus.Labels // the labels used for the last update, the node can change between scans
+	infoLabels  prometheus.Labels
+	errorLabels prometheus.Labels
+}
+
+// federationLinkKey identifies a federation link inside a cluster, the same upstream can be used in several
+// vhosts and by several exchanges or queues, and an exchange and a queue can share a name
+func federationLinkKey(vhost, upstream, linkType, resource string) string {
+	return vhost + "/" + upstream + "/" + linkType + "/" + resource
+}
+
+// resource returns the name of the local exchange or queue the link federates
+func (fl *federationLink) resource() string {
+	if fl.Type == "queue" {
+		return fl.Queue
+	}
+	return fl.Exchange
 }
 
 func (fl *federationLink) update(localFl *federationLink) {
 	fl.Status = localFl.Status
 	fl.Vhost = localFl.Vhost
 	fl.Name = localFl.Name
-	fl.Node = localFl.Name
+	fl.Node = localFl.Node
+	fl.Type = localFl.Type
+	fl.Exchange = localFl.Exchange
+	fl.Queue = localFl.Queue
+	fl.UpstreamExchange = localFl.UpstreamExchange
+	fl.UpstreamQueue = localFl.UpstreamQueue
+	fl.URI = localFl.URI
+	fl.Error = localFl.Error
 	fl.LocalChannel = localFl.LocalChannel
 }
 
+func (fl *federationLink) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster_name": fl.ClusterName,
+		"vhost":        fl.Vhost,
+		"name":         fl.Name,
+		"node":         fl.Node,
+		"type":         fl.Type,
+		"resource":     fl.resource(),
+		"environment":  environment,
+	}
+}
+
 func (fl *federationLink) updateMetrics() {
+	labels := fl.labels()
+	if fl.lastLabels != nil && !sameLabels(fl.lastLabels, labels) {
+		fl.deleteMetrics()
+	}
+	fl.lastLabels = labels
+
 	if fl.Status == "running" {
-		federationLinksGauges["running"].With(prometheus.Labels{
-			"cluster_name": fl.ClusterName,
-			"vhost":        fl.Vhost,
-			"name":         fl.Name,
-			"node":         fl.Node,
-			"environment":  environment,
-		}).Set(1)
+		federationLinksGauges["running"].With(labels).Set(1)
 	} else {
-		federationLinksGauges["running"].With(prometheus.Labels{
-			"cluster_name": fl.ClusterName,
-			"vhost":        fl.Vhost,
-			"name":         fl.Name,
-			"node":         fl.Node,
-			"environment":  environment,
-		}).Set(0)
+		federationLinksGauges["running"].With(labels).Set(0)
+	}
+
+	infoLabels := fl.labels()
+	infoLabels["upstream_uri"] = stripCredentials(fl.URI)
+	infoLabels["upstream_exchange"] = fl.UpstreamExchange
+	infoLabels["upstream_queue"] = fl.UpstreamQueue
+	if fl.infoLabels != nil && !sameLabels(fl.infoLabels, infoLabels) {
+		federationLinksGauges["info"].Delete(fl.infoLabels)
+	}
+	federationLinksGauges["info"].With(infoLabels).Set(1)
+	fl.infoLabels = infoLabels
+
+	// the error text is only reported while the link is failing
+	if fl.errorLabels != nil {
+		federationLinksGauges["error"].Delete(fl.errorLabels)
+		fl.errorLabels = nil
+	}
+	if fl.Status == "error" {
+		fl.errorLabels = fl.labels()
+		fl.errorLabels["error"] = fl.Error
+		federationLinksGauges["error"].With(fl.errorLabels).Set(1)
+	}
+
+	// a starting link has no local channel yet, there is nothing to report about it
+	if fl.LocalChannel == nil {
+		federationLinksGauges["channel_running"].With(labels).Set(0)
+		for _, name := range federationChannelGauges {
+			federationLinksGauges[name].Delete(labels)
+		}
+		return
 	}
 
 	if fl.LocalChannel.State == "running" {
-		federationLinksGauges["channel_running"].With(prometheus.Labels{
-			"cluster_name": fl.ClusterName,
-			"vhost":        fl.Vhost,
-			"name":         fl.Name,
-			"node":         fl.Node,
-			"environment":  environment,
-		}).Set(1)
+		federationLinksGauges["channel_running"].With(labels).Set(1)
 	} else {
-		federationLinksGauges["channel_running"].With(prometheus.Labels{
-			"cluster_name": fl.ClusterName,
-			"vhost":        fl.Vhost,
-			"name":         fl.Name,
-			"node":         fl.Node,
-			"environment":  environment,
-		}).Set(0)
+		federationLinksGauges["channel_running"].With(labels).Set(0)
 	}
-	federationLinksGauges["messages_unacknowledged"].With(prometheus.Labels{
-		"cluster_name": fl.ClusterName,
-		"vhost":        fl.Vhost,
-		"name":         fl.Name,
-		"node":         fl.Node,
-		"environment":  environment,
-	}).Set(float64(fl.LocalChannel.MessagesUnacknowledged))
-
-	federationLinksGauges["messages_uncommited"].With(prometheus.Labels{
-		"cluster_name": fl.ClusterName,
-		"vhost":        fl.Vhost,
-		"name":         fl.Name,
-		"node":         fl.Node,
-		"environment":  environment,
-	}).Set(float64(fl.LocalChannel.MessagesUncommited))
+	federationLinksGauges["messages_unacknowledged"].With(labels).Set(float64(fl.LocalChannel.MessagesUnacknowledged))
+	federationLinksGauges["messages_uncommited"].With(labels).Set(float64(fl.LocalChannel.MessagesUncommitted))
+	federationLinksGauges["messages_unconfirmed"].With(labels).Set(float64(fl.LocalChannel.MessagesUnconfirmed))
+	federationLinksGauges["consumers"].With(labels).Set(float64(fl.LocalChannel.ConsumerCount))
+	federationLinksGauges["publish_rate"].With(labels).Set(fl.LocalChannel.MessageStats.Publish.Rate)
+	federationLinksGauges["confirm_rate"].With(labels).Set(fl.LocalChannel.MessageStats.Confirm.Rate)
+}
 
-	federationLinksGauges["messages_unconfirmed"].With(prometheus.Labels{
-		"cluster_name": fl.ClusterName,
-		"vhost":        fl.Vhost,
-		"name":         fl.Name,
-		"node":         fl.Node,
-		"environment":  environment,
-	}).Set(float64(fl.LocalChannel.MessagesUnconfirmed))
+// removes all the series of a link that moved or does not exist anymore
+func (fl *federationLink) deleteMetrics() {
+	if fl.lastLabels != nil {
+		federationLinksGauges["running"].Delete(fl.lastLabels)
+		federationLinksGauges["channel_running"].Delete(fl.lastLabels)
+		for _, name := range federationChannelGauges {
+			federationLinksGauges[name].Delete(fl.lastLabels)
+		}
+	}
+	if fl.infoLabels != nil {
+		federationLinksGauges["info"].Delete(fl.infoLabels)
+		fl.infoLabels = nil
+	}
+	if fl.errorLabels != nil {
+		federationLinksGauges["error"].Delete(fl.errorLabels)
+		fl.errorLabels = nil
+	}
 }
 
 type federationLocalChannel struct {
-	MessagesUnacknowledged int    `json:"messages_unacknowledged"`
-	MessagesUncommited     int    `json:"messages_uncommited"`
-	MessagesUnconfirmed    int    `json:"messages_unconfirmed"`
-	State                  string `json:"state"`
+	MessagesUnacknowledged int                           `json:"messages_unacknowledged"`
+	MessagesUncommitted    int                           `json:"messages_uncommitted"`
+	MessagesUnconfirmed    int                           `json:"messages_unconfirmed"`
+	ConsumerCount          int                           `json:"consumer_count"`
+	State                  string                        `json:"state"`
+	MessageStats           federationChannelMessageStats `json:"message_stats"`
+}
+
+type federationChannelMessageStats struct {
+	Publish messageRate `json:"publish_details"`
+	Confirm messageRate `json:"confirm_details"`
 }
 
+// the gauges that are only reported while the link has a local channel
+var federationChannelGauges = []string{"messages_unacknowledged", "messages_uncommited", "messages_unconfirmed", "consumers", "publish_rate", "confirm_rate"}
+
 var federationLinksGauges = map[string]*prometheus.GaugeVec{
 	"running": prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rmq_federation_link_running",
 			Help: "Indicates if the current federation link is in a running state",
 		},
-		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
 	"messages_unacknowledged": prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rmq_federation_link_messages_unacknowledged",
 			Help: "The current amount of unacknowledged messages",
 		},
-		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
 	"messages_uncommited": prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rmq_federation_link_messages_uncommited",
 			Help: "The current amount of uncommited messages",
 		},
-		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
 	"messages_unconfirmed": prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rmq_federation_link_messages_unconfirmed",
 			Help: "The current amount of unconfirmed messages",
 		},
-		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
 	"channel_running": prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rmq_federation_link_channel_running",
 			Help: "Indicates whether the underlying channel is running",
 		},
-		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
+	"consumers": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_link_consumers",
+			Help: "The number of consumers on the local channel of the link",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
+	"publish_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_link_publish_rate",
+			Help: "Rate of messages the link publishes locally per second",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
+	"confirm_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_link_confirm_rate",
+			Help: "Rate of publisher confirms the link receives locally per second",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment"}),
+	"info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_link_info",
+			Help: "The upstream uri and upstream exchange or queue of the link, always 1",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment", "upstream_uri", "upstream_exchange", "upstream_queue"}),
+	"error": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_link_error",
+			Help: "Set while the link is failing, with the error reported by the broker",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "type", "resource", "environment", "error"}),
 }
 
 func registerFederationLinksMetrics() {
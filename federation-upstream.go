@@ -0,0 +1,211 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type federationUpstream struct {
+	Vhost string `json:"vhost"`
+	Name  string `json:"name"`
+}
+
+type federationUpstreamSet struct {
+	Vhost string                       `json:"vhost"`
+	Name  string                       `json:"name"`
+	Value []federationUpstreamSetEntry `json:"value"`
+}
+
+type federationUpstreamSetEntry struct {
+	Upstream string `json:"upstream"`
+}
+
+// federationUpstreams reconciles the configured upstreams and upstream sets with the live federation links
+type federationUpstreams struct {
+	Upstreams   []*federationUpstream
+	Sets        []*federationUpstreamSet
+	ClusterName string
+
+	RunningLinks map[string]map[string]int // running links per upstream key and node
+
+	expected      map[string]map[string]bool // the link types a policy asks for, per upstream key
+	exchangeLinks map[string]map[string]int  // running exchange links per upstream key and node
+	queueLinks    map[string]int             // running queue links per upstream key
+
+	series map[string][]prometheus.Labels // the series set during the last update, per gauge
+}
+
+// federationUpstreamKey identifies an upstream inside a cluster
+func federationUpstreamKey(vhost, name string) string {
+	return vhost + "/" + name
+}
+
+func (fu *federationUpstreams) update(localUpstreams *federationUpstreams) {
+	fu.Upstreams = localUpstreams.Upstreams
+	fu.Sets = localUpstreams.Sets
+}
+
+// counts the running links of every configured upstream on every node, and works out from the policies which
+// upstreams should have links at all
+func (fu *federationUpstreams) reconcile(nodes map[string]*node, links map[string]*federationLink, policies map[string]*policy) {
+	fu.RunningLinks = map[string]map[string]int{}
+	fu.exchangeLinks = map[string]map[string]int{}
+	fu.queueLinks = map[string]int{}
+	for _, upstream := range fu.Upstreams {
+		key := federationUpstreamKey(upstream.Vhost, upstream.Name)
+		perNode, exchangePerNode := map[string]int{}, map[string]int{}
+		for name := range nodes {
+			perNode[name] = 0
+			exchangePerNode[name] = 0
+		}
+		fu.RunningLinks[key] = perNode
+		fu.exchangeLinks[key] = exchangePerNode
+	}
+
+	for _, link := range links {
+		if link.Status != "running" {
+			continue
+		}
+		key := federationUpstreamKey(link.Vhost, link.Name)
+		perNode, exists := fu.RunningLinks[key]
+		if !exists {
+			continue
+		}
+		perNode[link.Node]++
+		if link.Type == "queue" {
+			fu.queueLinks[key]++
+		} else {
+			fu.exchangeLinks[key][link.Node]++
+		}
+	}
+
+	fu.expected = map[string]map[string]bool{}
+	for _, p := range policies {
+		types := []string{}
+		switch p.ApplyTo {
+		case "exchanges":
+			types = append(types, "exchange")
+		case "queues":
+			types = append(types, "queue")
+		default:
+			types = append(types, "exchange", "queue")
+		}
+		for _, name := range fu.referenced(p) {
+			key := federationUpstreamKey(p.Vhost, name)
+			if fu.expected[key] == nil {
+				fu.expected[key] = map[string]bool{}
+			}
+			for _, t := range types {
+				// a queue policy that matches no queue doesn't start any link
+				if t == "queue" && p.Queues == 0 {
+					continue
+				}
+				fu.expected[key][t] = true
+			}
+		}
+	}
+}
+
+// referenced returns the upstreams a policy federates from, directly or through an upstream set
+func (fu *federationUpstreams) referenced(p *policy) []string {
+	names := []string{}
+	if name, ok := p.Definition["federation-upstream"].(string); ok {
+		names = append(names, name)
+	}
+	set, ok := p.Definition["federation-upstream-set"].(string)
+	if !ok {
+		return names
+	}
+	for _, upstream := range fu.Upstreams {
+		if set == "all" && upstream.Vhost == p.Vhost {
+			names = append(names, upstream.Name)
+		}
+	}
+	for _, upstreamSet := range fu.Sets {
+		if upstreamSet.Vhost == p.Vhost && upstreamSet.Name == set {
+			for _, entry := range upstreamSet.Value {
+				names = append(names, entry.Upstream)
+			}
+		}
+	}
+	return names
+}
+
+func (fu *federationUpstreams) updateMetrics() {
+	current := map[string][]prometheus.Labels{}
+	set := func(name string, labels prometheus.Labels, value float64) {
+		labels["cluster_name"] = fu.ClusterName
+		labels["environment"] = environment
+		federationUpstreamGauges[name].With(labels).Set(value)
+		current[name] = append(current[name], labels)
+	}
+	flag := func(missing bool) float64 {
+		if missing {
+			return 1
+		}
+		return 0
+	}
+
+	configured := map[string]bool{}
+	for _, upstream := range fu.Upstreams {
+		key := federationUpstreamKey(upstream.Vhost, upstream.Name)
+		configured[key] = true
+
+		for node, count := range fu.RunningLinks[key] {
+			set("running_links", prometheus.Labels{"vhost": upstream.Vhost, "upstream": upstream.Name, "node": node}, float64(count))
+		}
+
+		// only the upstreams a policy uses should have links. Exchange federation runs a link on every node, a
+		// federated queue only on the node hosting it, so queue links are judged for the cluster as a whole
+		if fu.expected[key]["exchange"] {
+			for node, count := range fu.exchangeLinks[key] {
+				set("without_link", prometheus.Labels{"vhost": upstream.Vhost, "upstream": upstream.Name, "node": node}, flag(count == 0))
+			}
+		}
+		if fu.expected[key]["queue"] {
+			set("without_link", prometheus.Labels{"vhost": upstream.Vhost, "upstream": upstream.Name, "node": ""}, flag(fu.queueLinks[key] == 0))
+		}
+	}
+
+	for _, upstreamSet := range fu.Sets {
+		for _, entry := range upstreamSet.Value {
+			if !configured[federationUpstreamKey(upstreamSet.Vhost, entry.Upstream)] {
+				set("set_missing_upstream", prometheus.Labels{"vhost": upstreamSet.Vhost, "set": upstreamSet.Name, "upstream": entry.Upstream}, 1)
+			}
+		}
+	}
+
+	// upstreams and nodes come and go, drop whatever was not reported this time
+	for name, previous := range fu.series {
+		for _, labels := range previous {
+			if !containsLabels(current[name], labels) {
+				federationUpstreamGauges[name].Delete(labels)
+			}
+		}
+	}
+	fu.series = current
+}
+
+var federationUpstreamGauges = map[string]*prometheus.GaugeVec{
+	"running_links": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_upstream_running_links",
+			Help: "Number of running federation links to the configured upstream on the node",
+		},
+		[]string{"cluster_name", "environment", "vhost", "upstream", "node"}),
+	"without_link": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_upstream_without_link",
+			Help: "Indicates if an upstream used by a policy has no running federation link, per node for exchange federation",
+		},
+		[]string{"cluster_name", "environment", "vhost", "upstream", "node"}),
+	"set_missing_upstream": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_federation_upstream_set_missing_upstream",
+			Help: "Set for every upstream referenced by an upstream set that is not configured",
+		},
+		[]string{"cluster_name", "environment", "vhost", "set", "upstream"}),
+}
+
+func registerFederationUpstreamMetrics() {
+	for _, p := range federationUpstreamGauges {
+		prometheus.MustRegister(p)
+	}
+}
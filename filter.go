@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// objectFilter lets an object through when its name matches the include pattern, if there is one, and does not
+// match the exclude pattern
+type objectFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func newObjectFilter(include, exclude string) (objectFilter, error) {
+	f := objectFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return f, err
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (f objectFilter) allows(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// filters keep the cardinality of a cluster in check, the zero value lets everything through
+type filters struct {
+	Vhosts      objectFilter // also applies to every other object of the vhost
+	Queues      objectFilter
+	Exchanges   objectFilter
+	Connections objectFilter
+	MaxObjects  int // the most objects a single collector exports, 0 for no limit. Each object has several series
+}
+
+// dropped records the objects a collector left out of the metrics
+func (c *cluster) dropped(collector, reason string, count int) {
+	if count > 0 {
+		droppedObjects.With(prometheus.Labels{"cluster_name": c.ClusterName, "environment": environment, "collector": collector, "reason": reason}).Add(float64(count))
+	}
+}
+
+// limit applies the object cap to the keys of the objects a collector found, keeping the first ones by key so the
+// same objects make it in every scan
+func (c *cluster) limit(collector string, keys []string) map[string]bool {
+	sort.Strings(keys)
+	if c.Filters.MaxObjects > 0 && len(keys) > c.Filters.MaxObjects {
+		c.dropped(collector, "limit", len(keys)-c.Filters.MaxObjects)
+		keys = keys[:c.Filters.MaxObjects]
+	}
+	kept := map[string]bool{}
+	for _, key := range keys {
+		kept[key] = true
+	}
+	return kept
+}
+
+var droppedObjects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rmq_dropped_objects_total",
+		Help: "Objects left out of the metrics by the filters or the object limit of a collector",
+	},
+	[]string{"cluster_name", "environment", "collector", "reason"})
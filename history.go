@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// history keeps the snapshots of the past scans of every cluster for the retention period. When a directory
+// is given the snapshots are also appended to hourly files in it, so the history survives restarts.
+type history struct {
+	sync.RWMutex
+	retention  time.Duration
+	maxEntries int // the most snapshots kept per cluster, the oldest go first, 0 disables the cap
+	dir        string
+	byCluster  map[string][]*snapshot // oldest first
+}
+
+var scanHistory = &history{retention: time.Hour, maxEntries: 720, byCluster: map[string][]*snapshot{}}
+
+// historyPoint is a single value of a series, at the time of the scan that observed it
+type historyPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type historySeries struct {
+	Labels map[string]string `json:"labels"`
+	Points []historyPoint    `json:"points"`
+}
+
+// the fields identifying an object inside a resource, used to split the results of a query into series
+var historyIdentityFields = []string{"kind", "vhost", "name", "upstream", "exchange", "queue"}
+
+// adds the snapshot of a scan and forgets the ones older than the retention
+func (h *history) add(s *snapshot) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.byCluster[s.ClusterName] = append(h.byCluster[s.ClusterName], s)
+	h.trim(s.ClusterName, s.ScannedAt)
+
+	if h.dir != "" {
+		if err := h.persist(s); err != nil {
+			log.Println("Writing the history failed:", err)
+		}
+	}
+}
+
+// forget drops every snapshot of the cluster kept in memory, the persisted files are left alone
+func (h *history) forget(clusterName string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.byCluster, clusterName)
+}
+
+// trim drops the snapshots older than the retention and the oldest ones over the cap, expects the lock to be held
+func (h *history) trim(clusterName string, now time.Time) {
+	list := h.byCluster[clusterName]
+	cut := 0
+	for cut < len(list) && now.Sub(list[cut].ScannedAt) > h.retention {
+		cut++
+	}
+	if h.maxEntries > 0 && len(list)-cut > h.maxEntries {
+		cut = len(list) - h.maxEntries
+	}
+	if cut > 0 {
+		h.byCluster[clusterName] = append([]*snapshot{}, list[cut:]...)
+	}
+}
+
+// between returns the snapshots of the cluster taken between from and to, oldest first
+func (h *history) between(clusterName string, from, to time.Time) []*snapshot {
+	h.RLock()
+	defer h.RUnlock()
+
+	found := []*snapshot{}
+	for _, s := range h.byCluster[clusterName] {
+		if !s.ScannedAt.Before(from) && !s.ScannedAt.After(to) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// oldestSince returns the oldest snapshot of the cluster taken at or after the given time, nil when there is none
+func (h *history) oldestSince(clusterName string, since time.Time) *snapshot {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, s := range h.byCluster[clusterName] {
+		if !s.ScannedAt.Before(since) {
+			return s
+		}
+	}
+	return nil
+}
+
+// newestBefore returns the newest snapshot of the cluster taken at or before the given time, nil when there is none
+func (h *history) newestBefore(clusterName string, before time.Time) *snapshot {
+	h.RLock()
+	defer h.RUnlock()
+
+	list := h.byCluster[clusterName]
+	for i := len(list) - 1; i >= 0; i-- {
+		if !list[i].ScannedAt.After(before) {
+			return list[i]
+		}
+	}
+	return nil
+}
+
+// segment returns the file holding the snapshots of the cluster taken in the same hour as the given time
+func (h *history) segment(clusterName string, at time.Time) string {
+	return filepath.Join(h.dir, fmt.Sprintf("%s-%d.jsonl", fileSafe(clusterName), at.Unix()/3600))
+}
+
+// fileSafe makes a cluster name safe to use in a file name
+func fileSafe(name string) string {
+	return strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(name)
+}
+
+// appends the snapshot to its hourly segment and removes the segments that ended before the retention
+func (h *history) persist(s *snapshot) error {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.segment(s.ClusterName, s.ScannedAt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+
+	segments, err := filepath.Glob(filepath.Join(h.dir, fileSafe(s.ClusterName)+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	oldest := s.ScannedAt.Add(-h.retention).Unix() / 3600
+	for _, segment := range segments {
+		hour, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(segment), fileSafe(s.ClusterName)+"-"), ".jsonl"), 10, 64)
+		if err == nil && hour < oldest {
+			os.Remove(segment)
+		}
+	}
+	return nil
+}
+
+// load reads back the snapshots persisted in the history directory that are still inside the retention
+func (h *history) load(now time.Time) error {
+	segments, err := filepath.Glob(filepath.Join(h.dir, "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(segments)
+
+	h.Lock()
+	defer h.Unlock()
+	for _, segment := range segments {
+		if err := h.loadSegment(segment, now); err != nil {
+			return err
+		}
+	}
+	for clusterName, list := range h.byCluster {
+		sort.Slice(list, func(i, j int) bool { return list[i].ScannedAt.Before(list[j].ScannedAt) })
+		h.trim(clusterName, now)
+	}
+	return nil
+}
+
+// loadSegment reads the snapshots of a single hourly file, expects the lock to be held
+func (h *history) loadSegment(segment string, now time.Time) error {
+	file, err := os.Open(segment)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// a snapshot of a large cluster easily outgrows the default line limit of the scanner
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		s := &snapshot{}
+		if err := json.Unmarshal(scanner.Bytes(), s); err != nil {
+			log.Printf("Skipping a broken snapshot in %s: %s", segment, err)
+			continue
+		}
+		if now.Sub(s.ScannedAt) <= h.retention {
+			h.byCluster[s.ClusterName] = append(h.byCluster[s.ClusterName], s)
+		}
+	}
+	return scanner.Err()
+}
+
+// jsonField returns the field or map entry of an object by the name it has in the json api
+func jsonField(object reflect.Value, name string) (reflect.Value, bool) {
+	for object.Kind() == reflect.Ptr || object.Kind() == reflect.Interface {
+		if object.IsNil() {
+			return reflect.Value{}, false
+		}
+		object = object.Elem()
+	}
+	switch object.Kind() {
+	case reflect.Map:
+		if object.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		value := object.MapIndex(reflect.ValueOf(name).Convert(object.Type().Key()))
+		return value, value.IsValid()
+	case reflect.Struct:
+		for i := 0; i < object.NumField(); i++ {
+			field := object.Type().Field(i)
+			if field.PkgPath != "" || field.Anonymous {
+				continue
+			}
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == name {
+				return object.Field(i), true
+			}
+		}
+		// like the json encoding, the fields of an embedded struct come after the ones of the output around it
+		for i := 0; i < object.NumField(); i++ {
+			if field := object.Type().Field(i); field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if value, found := jsonField(object.Field(i), name); found {
+					return value, true
+				}
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// lookup follows a dotted path like message_stats.publish_details.rate inside an object of a snapshot
+func lookup(object reflect.Value, path string) (float64, bool) {
+	current := object
+	for _, part := range strings.Split(path, ".") {
+		var found bool
+		if current, found = jsonField(current, part); !found {
+			return 0, false
+		}
+	}
+	for current.Kind() == reflect.Ptr || current.Kind() == reflect.Interface {
+		if current.IsNil() {
+			return 0, false
+		}
+		current = current.Elem()
+	}
+	switch current.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(current.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(current.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return current.Float(), true
+	case reflect.Bool:
+		if current.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// query extracts a field of the objects of a resource from every snapshot, one series per matching object. The
+// fields are read straight from the snapshots, under the names they have in the json api
+func (h *history) query(clusterName, resource, field string, filters map[string]string, from, to time.Time) ([]*historySeries, error) {
+	bySeries := map[string]*historySeries{}
+	order := []string{}
+	for _, s := range h.between(clusterName, from, to) {
+		part, exists := s.resource(resource)
+		if !exists {
+			return nil, fmt.Errorf("unknown resource %s", resource)
+		}
+
+		objects := []reflect.Value{}
+		if value := reflect.ValueOf(part); value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				objects = append(objects, value.Index(i))
+			}
+		} else {
+			objects = append(objects, value)
+		}
+
+		for _, object := range objects {
+			if !matchesFilters(object, filters) {
+				continue
+			}
+			value, ok := lookup(object, field)
+			if !ok {
+				continue
+			}
+			labels := map[string]string{}
+			for _, name := range historyIdentityFields {
+				if identity, found := jsonField(object, name); found && identity.Kind() == reflect.String {
+					labels[name] = identity.String()
+				}
+			}
+			key := alertKey(labels)
+			if _, exists := bySeries[key]; !exists {
+				bySeries[key] = &historySeries{Labels: labels}
+				order = append(order, key)
+			}
+			bySeries[key].Points = append(bySeries[key].Points, historyPoint{Time: s.ScannedAt, Value: value})
+		}
+	}
+
+	series := make([]*historySeries, 0, len(order))
+	for _, key := range order {
+		series = append(series, bySeries[key])
+	}
+	return series, nil
+}
+
+func matchesFilters(object reflect.Value, filters map[string]string) bool {
+	for name, value := range filters {
+		field, found := jsonField(object, name)
+		if !found || fmt.Sprint(field) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTime accepts both rfc3339 and unix timestamps
+func parseTime(raw string, fallback time.Time) (time.Time, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// serves GET /api/v1/clusters/{name}/history?resource=queues&field=messages&vhost=/&name=orders&from=...&to=...
+// every parameter besides resource, field, from and to filters the objects on the field with the same name
+func serveHistory(w http.ResponseWriter, r *http.Request, clusterName string) {
+	query := r.URL.Query()
+	now := time.Now()
+	from, err := parseTime(query.Get("from"), now.Add(-scanHistory.retention))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad from: " + err.Error()})
+		return
+	}
+	to, err := parseTime(query.Get("to"), now)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad to: " + err.Error()})
+		return
+	}
+	resource, field := query.Get("resource"), query.Get("field")
+	if resource == "" || field == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "both resource and field are required"})
+		return
+	}
+
+	filters := map[string]string{}
+	for name := range query {
+		if name != "resource" && name != "field" && name != "from" && name != "to" {
+			filters[name] = query.Get(name)
+		}
+	}
+
+	series, err := scanHistory.query(clusterName, resource, field, filters, from, to)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cluster_name": clusterName,
+		"resource":     resource,
+		"field":        field,
+		"series":       series,
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// derivedLabel takes the value of a label from the vhost or queue of a series, for example
+// {"name": "team", "source": "queue", "pattern": "^(\\w+)\\.", "value": "$1"} or
+// {"name": "queue_type", "source": "argument", "key": "x-queue-type"}
+type derivedLabel struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`  // one of vhost, queue, argument or policy
+	Key     string `json:"key"`     // the queue argument, or the key of the effective policy definition, to read
+	Pattern string `json:"pattern"` // only label the series whose source matches
+	Value   string `json:"value"`   // expanded with the captures of the pattern, defaults to the whole match
+
+	pattern *regexp.Regexp
+}
+
+// derive turns the raw value of the source into the value of the label
+func (d *derivedLabel) derive(raw string) string {
+	if d.pattern == nil {
+		return raw
+	}
+	match := d.pattern.FindStringSubmatchIndex(raw)
+	if match == nil {
+		return ""
+	}
+	return string(d.pattern.ExpandString(nil, d.Value, raw, match))
+}
+
+// exportLabels adds the static and derived labels to everything the exporter emits, at gather time
+type exportLabels struct {
+	sync.RWMutex
+	static  map[string]map[string]string // the static labels by cluster name, under "" for the series of no cluster
+	derived []*derivedLabel
+	byQueue map[string]map[string]map[string]string // the argument and policy derived labels of every queue, by cluster name and queue key
+}
+
+var metricLabels = &exportLabels{static: map[string]map[string]string{}, byQueue: map[string]map[string]map[string]string{}}
+
+// parseStaticLabels reads labels written as region=eu-west-1,team=platform
+func parseStaticLabels(raw string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !labelName.MatchString(name) {
+			return nil, fmt.Errorf("bad label %q", pair)
+		}
+		labels[name] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// reads the derived labels from a json file holding a list of them
+func loadDerivedLabels(path string) ([]*derivedLabel, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	derived := []*derivedLabel{}
+	if err := json.Unmarshal(contents, &derived); err != nil {
+		return nil, err
+	}
+
+	for _, d := range derived {
+		if !labelName.MatchString(d.Name) {
+			return nil, fmt.Errorf("bad derived label name %q", d.Name)
+		}
+		switch d.Source {
+		case "vhost", "queue", "policy":
+		case "argument":
+			if d.Key == "" {
+				return nil, fmt.Errorf("derived label %s needs the key of the argument", d.Name)
+			}
+		default:
+			return nil, fmt.Errorf("derived label %s has an unknown source %q", d.Name, d.Source)
+		}
+		if d.Pattern != "" {
+			if d.pattern, err = regexp.Compile(d.Pattern); err != nil {
+				return nil, fmt.Errorf("derived label %s: %s", d.Name, err)
+			}
+			if d.Value == "" {
+				d.Value = "$0"
+			}
+		}
+	}
+	return derived, nil
+}
+
+// update works out the labels derived from the arguments and policies of the queues, runs after every scan
+func (l *exportLabels) update(c *cluster) {
+	byQueue := map[string]map[string]string{}
+	for _, q := range c.Queues {
+		values := map[string]string{}
+		for _, d := range l.derived {
+			raw, exists := "", false
+			switch {
+			case d.Source == "argument":
+				var value interface{}
+				if value, exists = q.Arguments[d.Key]; exists {
+					raw = fmt.Sprint(value)
+				}
+			case d.Source == "policy" && d.Key == "":
+				raw, exists = q.Policy, q.Policy != ""
+			case d.Source == "policy":
+				var value interface{}
+				if value, exists = q.EffectivePolicyDefinition[d.Key]; exists {
+					raw = fmt.Sprint(value)
+				}
+			}
+			if exists {
+				values[d.Name] = d.derive(raw)
+			}
+		}
+		byQueue[queueKey(q.Vhost, q.Name)] = values
+	}
+
+	// the other clusters keep the labels of their own last scan
+	l.Lock()
+	defer l.Unlock()
+	l.byQueue[c.ClusterName] = byQueue
+}
+
+// forget drops the derived labels of a cluster that is no longer scanned
+func (l *exportLabels) forget(clusterName string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.byQueue, clusterName)
+}
+
+// Gather hands out the metrics of the default registry with the static and derived labels added and the names of
+// the naming mode, a label the series already has is never overwritten
+func (l *exportLabels) Gather() ([]*dto.MetricFamily, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if len(l.static) == 0 && len(l.derived) == 0 {
+		return rename(families), err
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+	for _, family := range families {
+		// the alerts carry the labels of the series they fired on, those already went through here
+		if family.GetName() == "ALERTS" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			m.Label = l.relabel(m.GetLabel())
+		}
+	}
+	return rename(families), err
+}
+
+func (l *exportLabels) relabel(pairs []*dto.LabelPair) []*dto.LabelPair {
+	existing := map[string]string{}
+	for _, pair := range pairs {
+		existing[pair.GetName()] = pair.GetValue()
+	}
+	add := func(name, value string) {
+		if _, exists := existing[name]; exists || value == "" {
+			return
+		}
+		existing[name] = value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	clusterName := existing["cluster_name"]
+	for name, value := range l.static[clusterName] {
+		add(name, value)
+	}
+	vhost, hasVhost := existing["vhost"]
+	queue, hasQueue := existing["queue"]
+	for _, d := range l.derived {
+		switch {
+		case d.Source == "vhost" && hasVhost:
+			add(d.Name, d.derive(vhost))
+		case d.Source == "queue" && hasQueue:
+			add(d.Name, d.derive(queue))
+		case hasVhost && hasQueue:
+			add(d.Name, l.byQueue[clusterName][queueKey(vhost, queue)][d.Name])
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return pairs
+}
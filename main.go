@@ -1,10 +1,14 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -15,40 +19,338 @@ var (
 	password      = os.Getenv("PASSWORD")
 	sleepInterval = os.Getenv("SLEEP_INTERVAL")
 	host          = os.Getenv("HOST")
+	auditInterval = os.Getenv("AUDIT_INTERVAL")
+	rulesFile     = os.Getenv("RULES_FILE")
+	amURL         = os.Getenv("ALERTMANAGER_URL")
+	amResend      = os.Getenv("ALERTMANAGER_RESEND_INTERVAL")
+	webhooksFile  = os.Getenv("WEBHOOKS_FILE")
+	historyRetain = os.Getenv("HISTORY_RETENTION")
+	historyDir    = os.Getenv("HISTORY_DIR")
+	historyMax    = os.Getenv("HISTORY_MAX_SNAPSHOTS")
+	rateWindow    = os.Getenv("QUEUE_RATE_WINDOW")
+	stuckAfter    = os.Getenv("QUEUE_STUCK_AFTER")
+	slowBelow     = os.Getenv("QUEUE_SLOW_UTILISATION")
+	idleThreshold = os.Getenv("QUEUE_IDLE_THRESHOLD")
+	forecastOver  = os.Getenv("CAPACITY_WINDOW")
+	anomalies     = os.Getenv("ANOMALY_DETECTION")
+	anomalyWeight = os.Getenv("ANOMALY_ALPHA")
+	anomalyScore  = os.Getenv("ANOMALY_THRESHOLD")
+	maxObjects    = os.Getenv("MAX_OBJECTS_PER_COLLECTOR")
+	maxSeries     = os.Getenv("MAX_SERIES_PER_COLLECTOR") // the old name of MAX_OBJECTS_PER_COLLECTOR
+	groupsFile    = os.Getenv("QUEUE_GROUPS_FILE")
+	staticLabels  = os.Getenv("LABELS")
+	derivedFile   = os.Getenv("DERIVED_LABELS_FILE")
+	namespace     = os.Getenv("METRIC_NAMESPACE")
+	naming        = os.Getenv("METRIC_NAMING")
+	collection    = os.Getenv("COLLECTION_MODE")
+	scrapeWait    = os.Getenv("SCRAPE_TIMEOUT")
+	scrapeCache   = os.Getenv("SCRAPE_CACHE_TTL")
+	probeHosts    = os.Getenv("PROBE_TARGETS")
+	probeTTL      = os.Getenv("PROBE_TARGET_TTL")
 	sleepDuration time.Duration
+	auditDuration = time.Hour
+	clusterFilter filters
+	queueGroupSet []*queueGroupRule
+	probeLabels   map[string]map[string]string // the static labels of the probed clusters, by cluster name
 )
 
 func init() {
 	registerClusterMetrics()
+	registerOverviewMetrics()
 	registerNodeMetrics()
 	registerVhostMetrics()
 	registerQueueMetrics()
+	registerPolicyMetrics()
+	registerExchangeMetrics()
 	registerShovelMetrics()
 	registerFederationLinksMetrics()
+	registerFederationUpstreamMetrics()
+	registerAuditMetrics()
+	registerAnomalyMetrics()
+	registerQueueGroupMetrics()
+	prometheus.MustRegister(droppedObjects)
+	prometheus.MustRegister(alerts)
+}
+
+// reads the INCLUDE_<OBJECTS> and EXCLUDE_<OBJECTS> patterns and the series limit of the collectors
+func loadFilters() filters {
+	f := filters{}
+	for _, target := range []struct {
+		objects string
+		filter  *objectFilter
+	}{
+		{"VHOSTS", &f.Vhosts},
+		{"QUEUES", &f.Queues},
+		{"EXCHANGES", &f.Exchanges},
+		{"CONNECTIONS", &f.Connections},
+	} {
+		filter, err := newObjectFilter(os.Getenv("INCLUDE_"+target.objects), os.Getenv("EXCLUDE_"+target.objects))
+		if err != nil {
+			log.Fatalf("Could not parse the filters for the %s: %s", strings.ToLower(target.objects), err)
+		}
+		*target.filter = filter
+	}
+
+	if maxObjects == "" && maxSeries != "" {
+		log.Println("MAX_SERIES_PER_COLLECTOR is deprecated, it limits objects rather than series, use MAX_OBJECTS_PER_COLLECTOR")
+		maxObjects = maxSeries
+	}
+	if maxObjects != "" {
+		limit, err := strconv.Atoi(maxObjects)
+		if err != nil {
+			os.Exit(1)
+		}
+		f.MaxObjects = limit
+	}
+	return f
+}
+
+// reads the configuration needed by the long running exporter
+func configure() {
+	var err error
+	switch collection {
+	case "", collectBackground:
+		// process duration
+		sleep, err := time.ParseDuration(sleepInterval)
+		if err != nil {
+			os.Exit(1)
+		}
+		sleepDuration = sleep
+	case collectScrape:
+		collectionMode = collectScrape
+		if scrapeWait != "" {
+			if scrapeTimeout, err = time.ParseDuration(scrapeWait); err != nil {
+				os.Exit(1)
+			}
+		}
+		apiTimeout = scrapeTimeout
+		if scrapeCache != "" {
+			if scrapeCacheTTL, err = time.ParseDuration(scrapeCache); err != nil {
+				os.Exit(1)
+			}
+		}
+		probeTargets, probeLabels, err = parseProbeTargets(probeHosts)
+		if err != nil {
+			log.Fatalf("Could not parse PROBE_TARGETS: %s", err)
+		}
+		if probeTTL != "" {
+			if probeTargetTTL, err = time.ParseDuration(probeTTL); err != nil || probeTargetTTL <= 0 {
+				os.Exit(1)
+			}
+		}
+	default:
+		log.Fatalf("Unknown COLLECTION_MODE %q, use %s or %s", collection, collectBackground, collectScrape)
+	}
+
+	// the names of the metrics have to be settled before the built-in rules refer to them
+	if namespace != "" {
+		if !labelName.MatchString(namespace) {
+			log.Fatalf("Bad METRIC_NAMESPACE %q", namespace)
+		}
+		metricNamespace = namespace
+	}
+	switch naming {
+	case "", namingCompat:
+	case namingPrometheus:
+		metricNaming = namingPrometheus
+	default:
+		log.Fatalf("Unknown METRIC_NAMING %q, use %s or %s", naming, namingCompat, namingPrometheus)
+	}
+
+	// the audit is slow moving, it runs hourly unless told otherwise
+	if auditInterval != "" {
+		audit, err := time.ParseDuration(auditInterval)
+		if err != nil {
+			os.Exit(1)
+		}
+		auditDuration = audit
+	}
+
+	// the queue rates can only look as far back as the history reaches
+	if rateWindow != "" {
+		window, err := time.ParseDuration(rateWindow)
+		if err != nil {
+			os.Exit(1)
+		}
+		queueRateWindow = window
+	}
+	if stuckAfter != "" {
+		after, err := time.ParseDuration(stuckAfter)
+		if err != nil {
+			os.Exit(1)
+		}
+		queueStuckAfter = after
+	}
+	if slowBelow != "" {
+		utilisation, err := strconv.ParseFloat(slowBelow, 64)
+		if err != nil || utilisation < 0 || utilisation > 1 {
+			os.Exit(1)
+		}
+		queueSlowUtilisation = utilisation
+	}
+	if idleThreshold != "" {
+		threshold, err := time.ParseDuration(idleThreshold)
+		if err != nil {
+			os.Exit(1)
+		}
+		queueIdleThreshold = threshold
+	}
+	if forecastOver != "" {
+		window, err := time.ParseDuration(forecastOver)
+		if err != nil {
+			os.Exit(1)
+		}
+		capacityWindow = window
+	}
 
-	// process duration
-	sleep, err := time.ParseDuration(sleepInterval)
+	anomalyDetection = anomalies == "true"
+	if anomalyWeight != "" {
+		alpha, err := strconv.ParseFloat(anomalyWeight, 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			os.Exit(1)
+		}
+		anomalyAlpha = alpha
+	}
+	if anomalyScore != "" {
+		threshold, err := strconv.ParseFloat(anomalyScore, 64)
+		if err != nil {
+			os.Exit(1)
+		}
+		anomalyThreshold = threshold
+	}
+
+	clusterFilter = loadFilters()
+
+	// the labels every metric gets on top of its own, the probed clusters get their own
+	static, err := parseStaticLabels(staticLabels)
 	if err != nil {
-		os.Exit(1)
+		log.Fatalf("Could not parse LABELS: %s", err)
+	}
+	for name, labels := range probeLabels {
+		if len(labels) > 0 {
+			metricLabels.static[name] = labels
+		}
+	}
+	if len(static) > 0 {
+		metricLabels.static[""] = static
+		metricLabels.static[clusterName] = static
+	}
+	if derivedFile != "" {
+		metricLabels.derived, err = loadDerivedLabels(derivedFile)
+		if err != nil {
+			log.Fatalf("Could not load the derived labels from %s: %s", derivedFile, err)
+		}
+	}
+
+	if groupsFile != "" {
+		rules, err := loadQueueGroupRules(groupsFile)
+		if err != nil {
+			log.Fatalf("Could not load the queue groups from %s: %s", groupsFile, err)
+		}
+		queueGroupSet = rules
+	}
+
+	if rulesFile != "" {
+		rules, err := loadRules(rulesFile)
+		if err != nil {
+			log.Fatalf("Could not load the alert rules from %s: %s", rulesFile, err)
+		}
+		alerts.rules = rules
+	} else {
+		alerts.rules = builtinRules()
+		if anomalyDetection {
+			alerts.rules = append(alerts.rules, anomalyRules()...)
+		}
+	}
+
+	if amURL != "" {
+		resend := time.Minute
+		if amResend != "" {
+			resend, err = time.ParseDuration(amResend)
+			if err != nil {
+				os.Exit(1)
+			}
+		}
+		alerts.notifiers = append(alerts.notifiers, newAlertmanagerNotifier(amURL, resend))
+	}
+
+	if historyRetain != "" {
+		retention, err := time.ParseDuration(historyRetain)
+		if err != nil {
+			os.Exit(1)
+		}
+		scanHistory.retention = retention
+	}
+	// the forecast samples the nodes on its own, but after a restart it can only start from what the history kept
+	if capacityWindow > scanHistory.retention {
+		log.Printf("CAPACITY_WINDOW %s is longer than HISTORY_RETENTION %s, the forecast only reaches back %s after a restart", capacityWindow, scanHistory.retention, scanHistory.retention)
+	}
+	if historyMax != "" {
+		entries, err := strconv.Atoi(historyMax)
+		if err != nil || entries < 0 {
+			os.Exit(1)
+		}
+		scanHistory.maxEntries = entries
+	}
+	if historyDir != "" {
+		scanHistory.dir = historyDir
+		if err := scanHistory.load(time.Now()); err != nil {
+			log.Fatalf("Could not load the history from %s: %s", historyDir, err)
+		}
+	}
+
+	if webhooksFile != "" {
+		webhooks, err := loadWebhooks(webhooksFile)
+		if err != nil {
+			log.Fatalf("Could not load the webhooks from %s: %s", webhooksFile, err)
+		}
+		for _, w := range webhooks {
+			alerts.notifiers = append(alerts.notifiers, w)
+		}
 	}
-	sleepDuration = sleep
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+	configure()
+
 	localCluster := &cluster{
 		Address:     host,
 		Username:    username,
 		Password:    password,
 		ClusterName: clusterName,
+		Filters:     clusterFilter,
+		GroupRules:  queueGroupSet,
+	}
+
+	// when collecting on scrape, the scrapes drive the scans and the probes reach the other clusters
+	if collectionMode == collectScrape {
+		http.Handle("/metrics", scrapeHandler(&scrapeTarget{cluster: localCluster}))
+		probes := newScrapeTargets(*localCluster)
+		go probes.expire()
+		http.Handle("/probe", probes)
+	} else {
+		http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(metricLabels, promhttp.HandlerOpts{})))
+		go func() {
+			for {
+				localCluster.scan()
+				alerts.evaluate(time.Now())
+				time.Sleep(sleepDuration)
+			}
+		}()
 	}
 
 	go func() {
 		for {
-			localCluster.scan()
-			time.Sleep(sleepDuration)
+			localCluster.audit()
+			time.Sleep(auditDuration)
 		}
 	}()
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/api/v1/alerts", alerts)
+	http.HandleFunc("/api/v1/snapshot", serveSnapshots)
+	http.HandleFunc("/api/v1/clusters/", serveCluster)
 	http.ListenAndServe(":17762", nil)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// the naming modes of the exported metrics
+const (
+	namingCompat     = "compat"     // the names the exporter always had
+	namingPrometheus = "prometheus" // base units, _total counters and _info metrics, as the prometheus conventions ask
+)
+
+var (
+	metricNamespace = "rmq" // replaces the rmq prefix of every metric
+	metricNaming    = namingCompat
+)
+
+// metricRename describes how a metric is exported in the prometheus naming mode
+type metricRename struct {
+	name    string
+	scale   float64 // multiplies the value, 0 leaves it alone
+	counter bool    // the value only ever grows, it is exported as a counter
+}
+
+// the metrics whose compatible name does not follow the prometheus conventions
+var prometheusNames = map[string]metricRename{
+	"rmq_api_latency":               {name: "rmq_api_latency_seconds", scale: 1e-9},
+	"rmq_core_latency":              {name: "rmq_core_latency_seconds", scale: 1e-9},
+	"rmq_node_mem_max":              {name: "rmq_node_mem_max_bytes"},
+	"rmq_node_mem_current":          {name: "rmq_node_mem_current_bytes"},
+	"rmq_node_disk_min":             {name: "rmq_node_disk_min_bytes"},
+	"rmq_node_disk_current":         {name: "rmq_node_disk_current_bytes"},
+	"rmq_node_queue_memory":         {name: "rmq_node_queue_memory_bytes"},
+	"rmq_node_context_switch":       {name: "rmq_node_context_switches_total", counter: true},
+	"rmq_queue_memory":              {name: "rmq_queue_memory_bytes"},
+	"rmq_queue_message_bytes_ram":   {name: "rmq_queue_message_ram_bytes"},
+	"rmq_queue_group_memory":        {name: "rmq_queue_group_memory_bytes"},
+	"rmq_queue_policy":              {name: "rmq_queue_policy_info"},
+	"rmq_shovel_messages_forwarded": {name: "rmq_shovel_messages_forwarded_total", counter: true},
+}
+
+// exportName returns the name a metric is exported under in the current namespace and naming mode
+func exportName(name string) string {
+	if rename, exists := prometheusNames[name]; exists && metricNaming == namingPrometheus {
+		name = rename.name
+	}
+	if metricNamespace != "rmq" && strings.HasPrefix(name, "rmq_") {
+		name = metricNamespace + strings.TrimPrefix(name, "rmq")
+	}
+	return name
+}
+
+// rename applies the namespace and the naming mode to the gathered families
+func rename(families []*dto.MetricFamily) []*dto.MetricFamily {
+	if metricNamespace == "rmq" && metricNaming == namingCompat {
+		return families
+	}
+	for _, family := range families {
+		original := family.GetName()
+		name := exportName(original)
+		family.Name = &name
+
+		change, exists := prometheusNames[original]
+		if !exists || metricNaming != namingPrometheus {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.Gauge == nil {
+				continue
+			}
+			if change.scale != 0 {
+				value := m.Gauge.GetValue() * change.scale
+				m.Gauge.Value = &value
+			}
+			if change.counter {
+				m.Counter = &dto.Counter{Value: m.Gauge.Value}
+				m.Gauge = nil
+			}
+		}
+		if change.counter {
+			family.Type = dto.MetricType_COUNTER.Enum()
+		}
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families
+}
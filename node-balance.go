@@ -0,0 +1,75 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// the per node totals the imbalance of the cluster is scored on
+var balanceDimensions = []string{"queues", "quorum_leaders", "messages", "memory"}
+
+// counts the queues, quorum leaders, messages and queue memory hosted by every node, from the fresh list of queues
+func (c *cluster) distribution(queues []*queue) {
+	for _, node := range c.Nodes {
+		node.Queues, node.QuorumLeaders, node.QueueMessages, node.QueueMemory = 0, 0, 0, 0
+	}
+	for _, q := range queues {
+		node, exists := c.Nodes[q.Node]
+		if !exists {
+			continue
+		}
+		node.Queues++
+		node.QueueMessages += q.Messages
+		node.QueueMemory += q.Memory
+		// the node of a quorum queue is its leader
+		if q.Type == "quorum" {
+			node.QuorumLeaders++
+		}
+	}
+
+	c.Imbalance = map[string]float64{}
+	for _, dimension := range balanceDimensions {
+		values := []float64{}
+		for _, node := range c.Nodes {
+			values = append(values, node.balanceValue(dimension))
+		}
+		c.Imbalance[dimension] = imbalance(values)
+	}
+}
+
+func (n *node) balanceValue(dimension string) float64 {
+	switch dimension {
+	case "queues":
+		return float64(n.Queues)
+	case "quorum_leaders":
+		return float64(n.QuorumLeaders)
+	case "messages":
+		return float64(n.QueueMessages)
+	case "memory":
+		return float64(n.QueueMemory)
+	}
+	return 0
+}
+
+// imbalance scores how much more the busiest node holds than the average one, 0 for a perfectly balanced cluster
+// and nodes-1 when a single node holds everything
+func imbalance(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	total, highest := 0.0, 0.0
+	for _, value := range values {
+		total += value
+		if value > highest {
+			highest = value
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	mean := total / float64(len(values))
+	return highest/mean - 1
+}
+
+func (c *cluster) updateBalanceMetrics() {
+	for dimension, score := range c.Imbalance {
+		clusterGauges["imbalance"].With(prometheus.Labels{"cluster_name": c.ClusterName, "environment": environment, "dimension": dimension}).Set(score)
+	}
+}
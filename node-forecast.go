@@ -0,0 +1,154 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the trend of the nodes is fitted over the samples inside this window
+var capacityWindow = 24 * time.Hour
+
+// a node keeps at most this many usage samples, one per window/capacityMaxSamples, so a long window stays cheap
+const capacityMaxSamples = 96
+
+// a trend needs at least this many samples to be worth anything, the current scan included
+const capacityMinScans = 3
+
+// the resources the time until their limit is forecast for
+var capacityResources = []string{"disk", "memory", "fd", "sockets"}
+
+// usage returns the current value and the limit of a resource, and whether the value grows towards the limit
+func (n *node) usage(resource string) (value, limit float64, rising bool) {
+	switch resource {
+	case "disk":
+		// the free disk space shrinks towards the limit of the disk alarm
+		return float64(n.DiskCurrent), float64(n.DiskMin), false
+	case "memory":
+		return float64(n.MemCurrent), float64(n.MemMax), true
+	case "fd":
+		return float64(n.FdCurrent), float64(n.FdMax), true
+	case "sockets":
+		return float64(n.SockCurrent), float64(n.SockMax), true
+	}
+	return 0, 0, true
+}
+
+// usageSample is the usage of every capacity resource of a node at one point in time
+type usageSample struct {
+	at     time.Time
+	values []float64 // in the order of capacityResources
+}
+
+func (n *node) sample(at time.Time) usageSample {
+	values := make([]float64, len(capacityResources))
+	for i, resource := range capacityResources {
+		values[i], _, _ = n.usage(resource)
+	}
+	return usageSample{at: at, values: values}
+}
+
+// record keeps the usage of the node once per sampling step and forgets the samples that left the window
+func (n *node) record(s usageSample, now time.Time) {
+	step := capacityWindow / capacityMaxSamples
+	if len(n.samples) == 0 || s.at.Sub(n.samples[len(n.samples)-1].at) >= step {
+		n.samples = append(n.samples, s)
+	}
+	cut := 0
+	for cut < len(n.samples) && now.Sub(n.samples[cut].at) > capacityWindow {
+		cut++
+	}
+	n.samples = n.samples[cut:]
+}
+
+// forecasts for every node how long until each resource hits its limit, using a linear trend over its samples.
+// A node seen for the first time starts from the history, which only reaches back as far as its retention
+func (c *cluster) capacityForecast(now time.Time) {
+	var past []*snapshot
+	for _, n := range c.Nodes {
+		if n.samples != nil {
+			continue
+		}
+		if past == nil {
+			past = scanHistory.between(c.ClusterName, now.Add(-capacityWindow), now)
+		}
+		n.samples = []usageSample{}
+		for _, s := range past {
+			for _, old := range s.Nodes {
+				if old.Name == n.Name {
+					n.record(old.node.sample(s.ScannedAt), now)
+				}
+			}
+		}
+	}
+
+	for _, n := range c.Nodes {
+		n.TimeToLimit = map[string]float64{}
+		current := n.sample(now)
+		n.record(current, now)
+
+		// the current scan is always part of the fit, even between two sampling steps
+		samples := n.samples
+		if samples[len(samples)-1].at != now {
+			samples = append(samples[:len(samples):len(samples)], current)
+		}
+		if len(samples) < capacityMinScans {
+			continue
+		}
+		for i, resource := range capacityResources {
+			xs := make([]float64, 0, len(samples))
+			ys := make([]float64, 0, len(samples))
+			for _, s := range samples {
+				xs = append(xs, s.at.Sub(now).Seconds())
+				ys = append(ys, s.values[i])
+			}
+			value, limit, rising := n.usage(resource)
+
+			if limit <= 0 {
+				continue
+			}
+			slope := linearSlope(xs, ys)
+			switch {
+			case rising && value >= limit, !rising && value <= limit:
+				n.TimeToLimit[resource] = 0
+			case rising && slope > 0:
+				n.TimeToLimit[resource] = (limit - value) / slope
+			case !rising && slope < 0:
+				n.TimeToLimit[resource] = (value - limit) / -slope
+			}
+		}
+	}
+}
+
+// linearSlope fits a least squares line through the points and returns its slope, a flat series gives exactly 0
+func linearSlope(xs, ys []float64) float64 {
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(len(xs))
+	meanY /= float64(len(ys))
+
+	var covariance, variance float64
+	for i := range xs {
+		covariance += (xs[i] - meanX) * (ys[i] - meanY)
+		variance += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if variance == 0 {
+		return 0
+	}
+	return covariance / variance
+}
+
+// exports the forecasts, a resource that is not heading towards its limit has no series
+func (n *node) updateForecastMetrics() {
+	for _, resource := range capacityResources {
+		labels := prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name, "resource": resource}
+		if seconds, exists := n.TimeToLimit[resource]; exists {
+			nodeGauges["time_to_limit"].With(labels).Set(seconds)
+		} else {
+			nodeGauges["time_to_limit"].Delete(labels)
+		}
+	}
+}
@@ -5,22 +5,35 @@ import (
 )
 
 type node struct {
-	FdMax         int    `json:"fd_total"`
-	FdCurrent     int    `json:"fd_used"`
-	SockMax       int    `json:"sockets_total"`
-	SockCurrent   int    `json:"sockets_used"`
-	ProcMax       int    `json:"proc_total"`
-	ProcCurrent   int    `json:"proc_used"`
-	MemMax        int    `json:"mem_limit"`
-	MemCurrent    int    `json:"mem_used"`
-	DiskMin       int    `json:"disk_free_limit"`
-	DiskCurrent   int    `json:"disk_free"`
-	MemAlarm      bool   `json:"mem_alarm"`
-	DiskAlarm     bool   `json:"disk_free_alarm"`
-	ContextSwitch int    `json:"context_switches"`
-	Name          string `json:"name"`
-	Type          string `json:"type"`
-	ClusterName   string
+	FdMax         int      `json:"fd_total"`
+	FdCurrent     int      `json:"fd_used"`
+	SockMax       int      `json:"sockets_total"`
+	SockCurrent   int      `json:"sockets_used"`
+	ProcMax       int      `json:"proc_total"`
+	ProcCurrent   int      `json:"proc_used"`
+	MemMax        int      `json:"mem_limit"`
+	MemCurrent    int      `json:"mem_used"`
+	DiskMin       int      `json:"disk_free_limit"`
+	DiskCurrent   int      `json:"disk_free"`
+	MemAlarm      bool     `json:"mem_alarm"`
+	DiskAlarm     bool     `json:"disk_free_alarm"`
+	ContextSwitch int      `json:"context_switches"`
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Partitions    []string `json:"partitions"` // the nodes this node sees as partitioned away
+	ClusterName   string   `json:"-"`
+
+	// counted from the queues hosted by the node, never decoded from the api, the snapshot hands them out
+	// through nodeOutput
+	Queues        int `json:"-"`
+	QuorumLeaders int `json:"-"`
+	QueueMessages int `json:"-"`
+	QueueMemory   int `json:"-"`
+
+	// the forecast time until each resource hits its limit, only for the resources heading towards it
+	TimeToLimit map[string]float64 `json:"-"`
+
+	samples []usageSample // the downsampled usage the forecast is fitted on, nil until the node was first forecast
 }
 
 func (n *node) update(localNode *node) {
@@ -30,6 +43,7 @@ func (n *node) update(localNode *node) {
 	n.SockCurrent = localNode.SockCurrent
 	n.ProcMax = localNode.ProcMax
 	n.ProcCurrent = localNode.ProcCurrent
+	n.MemMax = localNode.MemMax
 	n.MemCurrent = localNode.MemCurrent
 	n.DiskMin = localNode.DiskMin
 	n.DiskCurrent = localNode.DiskCurrent
@@ -38,6 +52,7 @@ func (n *node) update(localNode *node) {
 	n.ContextSwitch = localNode.ContextSwitch
 	n.Name = localNode.Name
 	n.Type = localNode.Type
+	n.Partitions = localNode.Partitions
 }
 
 func (n *node) updateMetrics() {
@@ -62,6 +77,23 @@ func (n *node) updateMetrics() {
 		nodeGauges["disk_alarm"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(0)
 	}
 	nodeGauges["context_switch"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(n.ContextSwitch))
+	nodeGauges["partitions"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(len(n.Partitions)))
+	nodeGauges["queues"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(n.Queues))
+	nodeGauges["quorum_leaders"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(n.QuorumLeaders))
+	nodeGauges["queue_messages"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(n.QueueMessages))
+	nodeGauges["queue_memory"].With(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}).Set(float64(n.QueueMemory))
+	n.updateForecastMetrics()
+}
+
+// deleteMetrics removes every series of a node that left the cluster
+func (n *node) deleteMetrics() {
+	labels := prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name}
+	for _, gauge := range nodeGauges {
+		gauge.Delete(labels)
+	}
+	for _, resource := range capacityResources {
+		nodeGauges["time_to_limit"].Delete(prometheus.Labels{"cluster_name": n.ClusterName, "environment": environment, "node": n.Name, "resource": resource})
+	}
 }
 
 var nodeGauges = map[string]*prometheus.GaugeVec{
@@ -143,6 +175,42 @@ var nodeGauges = map[string]*prometheus.GaugeVec{
 			Help: "The current amount of context switches for the current node",
 		},
 		[]string{"cluster_name", "node", "environment"}),
+	"partitions": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_partitions",
+			Help: "The number of nodes the current node sees as partitioned away",
+		},
+		[]string{"cluster_name", "node", "environment"}),
+	"queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_queues",
+			Help: "The number of queues hosted by the node",
+		},
+		[]string{"cluster_name", "node", "environment"}),
+	"quorum_leaders": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_quorum_leaders",
+			Help: "The number of quorum queues the node is the leader of",
+		},
+		[]string{"cluster_name", "node", "environment"}),
+	"queue_messages": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_queue_messages",
+			Help: "The total number of messages in the queues hosted by the node",
+		},
+		[]string{"cluster_name", "node", "environment"}),
+	"queue_memory": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_queue_memory",
+			Help: "The memory used by the queues hosted by the node in bytes",
+		},
+		[]string{"cluster_name", "node", "environment"}),
+	"time_to_limit": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_node_time_to_limit_seconds",
+			Help: "Forecast time until the disk alarm, memory high watermark, file descriptor or socket limit is hit at the current trend",
+		},
+		[]string{"cluster_name", "node", "environment", "resource"}),
 }
 
 func registerNodeMetrics() {
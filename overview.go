@@ -0,0 +1,226 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type overview struct {
+	ManagementVersion string               `json:"management_version"`
+	RabbitmqVersion   string               `json:"rabbitmq_version"`
+	ErlangVersion     string               `json:"erlang_version"`
+	BrokerName        string               `json:"cluster_name"` // the name of the cluster as reported by the broker
+	MessageStats      overviewMessageStats `json:"message_stats"`
+	QueueTotals       overviewQueueTotals  `json:"queue_totals"`
+	ObjectTotals      overviewObjectTotals `json:"object_totals"`
+	ClusterName       string               `json:"-"` // the configured name, the snapshot carries it
+
+	infoLabels prometheus.Labels // the labels used for the last info metric, so they can be removed when versions change
+}
+
+type overviewMessageStats struct {
+	Publish          messageRate `json:"publish_details"`
+	Confirm          messageRate `json:"confirm_details"`
+	Deliver          messageRate `json:"deliver_details"`
+	DeliverGet       messageRate `json:"deliver_get_details"`
+	Ack              messageRate `json:"ack_details"`
+	Redeliver        messageRate `json:"redeliver_details"`
+	ReturnUnroutable messageRate `json:"return_unroutable_details"`
+	DiskReads        messageRate `json:"disk_reads_details"`
+	DiskWrites       messageRate `json:"disk_writes_details"`
+}
+
+type overviewQueueTotals struct {
+	Messages               int `json:"messages"`
+	MessagesReady          int `json:"messages_ready"`
+	MessagesUnacknowledged int `json:"messages_unacknowledged"`
+}
+
+type overviewObjectTotals struct {
+	Queues      int `json:"queues"`
+	Exchanges   int `json:"exchanges"`
+	Connections int `json:"connections"`
+	Channels    int `json:"channels"`
+	Consumers   int `json:"consumers"`
+}
+
+// messageRate is the "*_details" object the management api attaches to every counter
+type messageRate struct {
+	Rate float64 `json:"rate"`
+}
+
+func (o *overview) update(localOverview *overview) {
+	o.ManagementVersion = localOverview.ManagementVersion
+	o.RabbitmqVersion = localOverview.RabbitmqVersion
+	o.ErlangVersion = localOverview.ErlangVersion
+	o.BrokerName = localOverview.BrokerName
+	o.MessageStats = localOverview.MessageStats
+	o.QueueTotals = localOverview.QueueTotals
+	o.ObjectTotals = localOverview.ObjectTotals
+}
+
+func (o *overview) updateMetrics() {
+	labels := prometheus.Labels{"cluster_name": o.ClusterName, "environment": environment}
+
+	overviewGauges["queues"].With(labels).Set(float64(o.ObjectTotals.Queues))
+	overviewGauges["exchanges"].With(labels).Set(float64(o.ObjectTotals.Exchanges))
+	overviewGauges["connections"].With(labels).Set(float64(o.ObjectTotals.Connections))
+	overviewGauges["channels"].With(labels).Set(float64(o.ObjectTotals.Channels))
+	overviewGauges["consumers"].With(labels).Set(float64(o.ObjectTotals.Consumers))
+
+	overviewGauges["messages"].With(labels).Set(float64(o.QueueTotals.Messages))
+	overviewGauges["messages_ready"].With(labels).Set(float64(o.QueueTotals.MessagesReady))
+	overviewGauges["messages_unacknowledged"].With(labels).Set(float64(o.QueueTotals.MessagesUnacknowledged))
+
+	overviewGauges["publish_rate"].With(labels).Set(o.MessageStats.Publish.Rate)
+	overviewGauges["confirm_rate"].With(labels).Set(o.MessageStats.Confirm.Rate)
+	overviewGauges["deliver_rate"].With(labels).Set(o.MessageStats.Deliver.Rate)
+	overviewGauges["deliver_get_rate"].With(labels).Set(o.MessageStats.DeliverGet.Rate)
+	overviewGauges["ack_rate"].With(labels).Set(o.MessageStats.Ack.Rate)
+	overviewGauges["redeliver_rate"].With(labels).Set(o.MessageStats.Redeliver.Rate)
+	overviewGauges["return_unroutable_rate"].With(labels).Set(o.MessageStats.ReturnUnroutable.Rate)
+	overviewGauges["disk_reads_rate"].With(labels).Set(o.MessageStats.DiskReads.Rate)
+	overviewGauges["disk_writes_rate"].With(labels).Set(o.MessageStats.DiskWrites.Rate)
+
+	// the versions change on upgrades, drop the old series so only the current one reports 1
+	infoLabels := prometheus.Labels{
+		"cluster_name":       o.ClusterName,
+		"environment":        environment,
+		"broker_name":        o.BrokerName,
+		"rabbitmq_version":   o.RabbitmqVersion,
+		"erlang_version":     o.ErlangVersion,
+		"management_version": o.ManagementVersion,
+	}
+	if o.infoLabels != nil && !sameLabels(o.infoLabels, infoLabels) {
+		overviewGauges["info"].Delete(o.infoLabels)
+	}
+	overviewGauges["info"].With(infoLabels).Set(1)
+	o.infoLabels = infoLabels
+}
+
+// sameLabels reports whether both label sets hold exactly the same values
+func sameLabels(a, b prometheus.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+var overviewGauges = map[string]*prometheus.GaugeVec{
+	"queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_queues",
+			Help: "Total number of queues in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"exchanges": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_exchanges",
+			Help: "Total number of exchanges in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"connections": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_connections",
+			Help: "Total number of connections in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"channels": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_channels",
+			Help: "Total number of channels in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"consumers": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_consumers",
+			Help: "Total number of consumers in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"messages": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_messages",
+			Help: "Total number of messages in all the queues of the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"messages_ready": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_messages_ready",
+			Help: "Total number of messages ready for delivery in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"messages_unacknowledged": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_messages_unacknowledged",
+			Help: "Total number of delivered but unacknowledged messages in the cluster",
+		},
+		[]string{"cluster_name", "environment"}),
+	"publish_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_publish_rate",
+			Help: "Cluster wide rate of published messages per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"confirm_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_confirm_rate",
+			Help: "Cluster wide rate of publisher confirms per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"deliver_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_deliver_rate",
+			Help: "Cluster wide rate of messages delivered to consumers in acknowledgement mode per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"deliver_get_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_deliver_get_rate",
+			Help: "Cluster wide rate of messages delivered or fetched per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"ack_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_ack_rate",
+			Help: "Cluster wide rate of acknowledged messages per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"redeliver_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_redeliver_rate",
+			Help: "Cluster wide rate of redelivered messages per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"return_unroutable_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_return_unroutable_rate",
+			Help: "Cluster wide rate of messages returned to the publisher as unroutable per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"disk_reads_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_disk_reads_rate",
+			Help: "Cluster wide rate of messages read from disk per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"disk_writes_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_disk_writes_rate",
+			Help: "Cluster wide rate of messages written to disk per second",
+		},
+		[]string{"cluster_name", "environment"}),
+	"info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_overview_info",
+			Help: "Versions of the cluster and the name reported by the broker, always 1",
+		},
+		[]string{"cluster_name", "environment", "broker_name", "rabbitmq_version", "erlang_version", "management_version"}),
+}
+
+func registerOverviewMetrics() {
+	for _, p := range overviewGauges {
+		prometheus.MustRegister(p)
+	}
+}
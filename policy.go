@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type policy struct {
+	Vhost       string           `json:"vhost"`
+	Name        string           `json:"name"`
+	Pattern     string           `json:"pattern"`
+	ApplyTo     string           `json:"apply-to"`
+	Priority    int              `json:"priority"`
+	Definition  policyDefinition `json:"definition"`
+	Kind        string           `json:"-"` // either policy or operator_policy, handed out through policyOutput
+	ClusterName string           `json:"-"`
+
+	Queues int `json:"-"` // the number of queues this policy is currently applied to, handed out through policyOutput
+
+	infoLabels prometheus.Labels // the labels used for the last info metric
+}
+
+// policyDefinition decodes a definition that the broker sends as an empty list when there is nothing in it
+type policyDefinition map[string]interface{}
+
+func (d *policyDefinition) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "[]" {
+		*d = policyDefinition{}
+		return nil
+	}
+	definition := map[string]interface{}{}
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return err
+	}
+	*d = definition
+	return nil
+}
+
+// keys returns the sorted keys of the definition
+func (d policyDefinition) keys() []string {
+	keys := make([]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// policyKey identifies a policy inside a cluster
+func policyKey(kind, vhost, name string) string {
+	return kind + "/" + vhost + "/" + name
+}
+
+func (p *policy) update(localPolicy *policy) {
+	p.Vhost = localPolicy.Vhost
+	p.Name = localPolicy.Name
+	p.Pattern = localPolicy.Pattern
+	p.ApplyTo = localPolicy.ApplyTo
+	p.Priority = localPolicy.Priority
+	p.Definition = localPolicy.Definition
+	p.Kind = localPolicy.Kind
+}
+
+func (p *policy) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster_name": p.ClusterName,
+		"environment":  environment,
+		"vhost":        p.Vhost,
+		"name":         p.Name,
+		"kind":         p.Kind,
+	}
+}
+
+func (p *policy) updateMetrics() {
+	policyGauges["queues"].With(p.labels()).Set(float64(p.Queues))
+
+	infoLabels := p.labels()
+	infoLabels["pattern"] = p.Pattern
+	infoLabels["apply_to"] = p.ApplyTo
+	infoLabels["priority"] = strconv.Itoa(p.Priority)
+	infoLabels["definition_keys"] = strings.Join(p.Definition.keys(), ",")
+	if p.infoLabels != nil && !sameLabels(p.infoLabels, infoLabels) {
+		policyGauges["info"].Delete(p.infoLabels)
+	}
+	policyGauges["info"].With(infoLabels).Set(1)
+	p.infoLabels = infoLabels
+}
+
+// removes all the series of a policy that does not exist anymore
+func (p *policy) deleteMetrics() {
+	policyGauges["queues"].Delete(p.labels())
+	if p.infoLabels != nil {
+		policyGauges["info"].Delete(p.infoLabels)
+	}
+}
+
+var policyGauges = map[string]*prometheus.GaugeVec{
+	"info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_policy_info",
+			Help: "Pattern, target, priority and definition keys of the policy, always 1",
+		},
+		[]string{"cluster_name", "environment", "vhost", "name", "kind", "pattern", "apply_to", "priority", "definition_keys"}),
+	"queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_policy_queues",
+			Help: "Number of queues the policy is currently applied to",
+		},
+		[]string{"cluster_name", "environment", "vhost", "name", "kind"}),
+}
+
+func registerPolicyMetrics() {
+	for _, p := range policyGauges {
+		prometheus.MustRegister(p)
+	}
+}
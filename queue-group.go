@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queueGroupRule maps the queues with a name matching the pattern to a group, for example
+// {"pattern": "^(orders\\.worker)-[0-9a-f-]+$", "group": "$1"} puts every orders.worker-<uuid> into orders.worker
+type queueGroupRule struct {
+	Pattern    string `json:"pattern"`
+	Group      string `json:"group"`       // expanded with the captures of the pattern
+	KeepQueues bool   `json:"keep_queues"` // also export the queues of the group one by one
+
+	pattern *regexp.Regexp
+}
+
+// queueGroup aggregates the queues of a group inside a vhost
+type queueGroup struct {
+	Vhost       string `json:"vhost"`
+	Name        string `json:"name"`
+	Queues      int    `json:"queues"`
+	Messages    int    `json:"messages"`
+	Consumers   int    `json:"consumers"`
+	Memory      int    `json:"memory"`
+	ClusterName string `json:"-"`
+}
+
+// queueGroupKey identifies a group inside a cluster
+func queueGroupKey(vhost, name string) string {
+	return vhost + "/" + name
+}
+
+// reads the queue group rules from a json file holding a list of them
+func loadQueueGroupRules(path string) ([]*queueGroupRule, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*queueGroupRule{}
+	if err := json.Unmarshal(contents, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if r.Pattern == "" || r.Group == "" {
+			return nil, fmt.Errorf("every queue group rule needs both a pattern and a group")
+		}
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("queue group rule %q: %s", r.Pattern, err)
+		}
+	}
+	return rules, nil
+}
+
+// groupOf returns the group of a queue and whether the queue keeps its own metrics, the first matching rule wins
+func (c *cluster) groupOf(q *queue) (string, bool, bool) {
+	for _, r := range c.GroupRules {
+		match := r.pattern.FindStringSubmatchIndex(q.Name)
+		if match == nil {
+			continue
+		}
+		return string(r.pattern.ExpandString(nil, r.Group, q.Name, match)), r.KeepQueues, true
+	}
+	return "", true, false
+}
+
+// adds up the queues of every group, the queues given are the ones that passed the filters
+func (c *cluster) queueGroups(queues []*queue) {
+	groups := map[string]*queueGroup{}
+	for _, q := range queues {
+		name, _, grouped := c.groupOf(q)
+		if !grouped {
+			continue
+		}
+		key := queueGroupKey(q.Vhost, name)
+		group, exists := groups[key]
+		if !exists {
+			group = &queueGroup{Vhost: q.Vhost, Name: name, ClusterName: c.ClusterName}
+			groups[key] = group
+		}
+		group.Queues++
+		group.Messages += q.Messages
+		group.Consumers += q.Consumers
+		group.Memory += q.Memory
+	}
+
+	for key, group := range c.QueueGroups {
+		if _, exists := groups[key]; !exists {
+			group.deleteMetrics()
+		}
+	}
+	c.QueueGroups = groups
+}
+
+func (g *queueGroup) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster_name": g.ClusterName,
+		"environment":  environment,
+		"vhost":        g.Vhost,
+		"group":        g.Name,
+	}
+}
+
+func (g *queueGroup) updateMetrics() {
+	queueGroupGauges["queues"].With(g.labels()).Set(float64(g.Queues))
+	queueGroupGauges["messages"].With(g.labels()).Set(float64(g.Messages))
+	queueGroupGauges["consumers"].With(g.labels()).Set(float64(g.Consumers))
+	queueGroupGauges["memory"].With(g.labels()).Set(float64(g.Memory))
+}
+
+// removes all the series of a group that has no queues anymore
+func (g *queueGroup) deleteMetrics() {
+	for _, gauge := range queueGroupGauges {
+		gauge.Delete(g.labels())
+	}
+}
+
+var queueGroupGauges = map[string]*prometheus.GaugeVec{
+	"queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_group_queues",
+			Help: "Number of queues in the group",
+		},
+		[]string{"cluster_name", "environment", "vhost", "group"}),
+	"messages": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_group_messages",
+			Help: "Total number of messages in the queues of the group",
+		},
+		[]string{"cluster_name", "environment", "vhost", "group"}),
+	"consumers": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_group_consumers",
+			Help: "Total number of consumers of the queues of the group",
+		},
+		[]string{"cluster_name", "environment", "vhost", "group"}),
+	"memory": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_group_memory",
+			Help: "Memory consumed by the queues of the group in bytes",
+		},
+		[]string{"cluster_name", "environment", "vhost", "group"}),
+}
+
+func registerQueueGroupMetrics() {
+	for _, p := range queueGroupGauges {
+		prometheus.MustRegister(p)
+	}
+}
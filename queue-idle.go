@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// queues idle for longer than this are listed by the idle queues report unless the request asks otherwise
+var queueIdleThreshold = 24 * time.Hour
+
+// the broker has written idle_since in several layouts over the versions
+var idleSinceLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05.000-07:00", time.RFC3339}
+
+// idleQueue is an entry of the idle queues report
+type idleQueue struct {
+	Vhost        string    `json:"vhost"`
+	Name         string    `json:"name"`
+	Node         string    `json:"node"`
+	Messages     int       `json:"messages"`
+	MessageBytes int       `json:"message_bytes"`
+	Consumers    int       `json:"consumers"`
+	Orphaned     bool      `json:"orphaned"` // nobody consumes from it either
+	LastActivity time.Time `json:"last_activity"`
+	IdleSeconds  float64   `json:"idle_seconds"`
+}
+
+func parseIdleSince(raw string) time.Time {
+	for _, layout := range idleSinceLayouts {
+		if parsed, err := time.Parse(layout, raw); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// tracks when every queue was last active, a queue is active when its messages, counters or consumers change
+func (c *cluster) queueActivity(now time.Time) {
+	then := map[string]queueOutput{}
+	if previous := scanHistory.newestBefore(c.ClusterName, now); previous != nil {
+		for _, q := range previous.Queues {
+			then[queueKey(q.Vhost, q.Name)] = q
+		}
+	}
+
+	for _, q := range c.Queues {
+		old, known := then[queueKey(q.Vhost, q.Name)]
+		if known && q.LastActivity.IsZero() {
+			// the history outlives a restart, the queues do not
+			q.LastActivity = old.LastActivity
+		}
+		switch {
+		case known && q.changedSince(old.queue):
+			q.LastActivity = now
+		case q.LastActivity.IsZero():
+			q.LastActivity = now
+			if idleSince := parseIdleSince(q.IdleSince); !idleSince.IsZero() && idleSince.Before(now) {
+				q.LastActivity = idleSince
+			}
+		}
+	}
+}
+
+func (q *queue) changedSince(old queue) bool {
+	return q.Messages != old.Messages ||
+		q.Consumers != old.Consumers ||
+		q.MessageStats.Publish != old.MessageStats.Publish ||
+		q.MessageStats.DeliverGet != old.MessageStats.DeliverGet ||
+		q.MessageStats.removed() != old.MessageStats.removed()
+}
+
+// idleQueues lists the queues of the snapshot idle for at least the threshold, the longest idle first
+func (s *snapshot) idleQueues(threshold time.Duration) []idleQueue {
+	idle := []idleQueue{}
+	for _, q := range s.Queues {
+		if q.LastActivity.IsZero() || s.ScannedAt.Sub(q.LastActivity) < threshold {
+			continue
+		}
+		idle = append(idle, idleQueue{
+			Vhost:        q.Vhost,
+			Name:         q.Name,
+			Node:         q.Node,
+			Messages:     q.Messages,
+			MessageBytes: q.MessageBytes,
+			Consumers:    q.Consumers,
+			Orphaned:     q.Consumers == 0,
+			LastActivity: q.LastActivity,
+			IdleSeconds:  s.ScannedAt.Sub(q.LastActivity).Seconds(),
+		})
+	}
+	sort.SliceStable(idle, func(i, j int) bool { return idle[i].LastActivity.Before(idle[j].LastActivity) })
+	return idle
+}
+
+// serves GET /api/v1/clusters/{name}/idle-queues?threshold=24h&orphaned=true
+func serveIdleQueues(w http.ResponseWriter, r *http.Request, s *snapshot) {
+	threshold := queueIdleThreshold
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad threshold: " + err.Error()})
+			return
+		}
+		threshold = parsed
+	}
+
+	idle := s.idleQueues(threshold)
+	if r.URL.Query().Get("orphaned") == "true" {
+		orphaned := []idleQueue{}
+		for _, q := range idle {
+			if q.Orphaned {
+				orphaned = append(orphaned, q)
+			}
+		}
+		idle = orphaned
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cluster_name": s.ClusterName,
+		"scanned_at":   s.ScannedAt,
+		"threshold":    threshold.String(),
+		"queues":       idle,
+	})
+}
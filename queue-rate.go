@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// the rates of a queue are averaged over this window, using the oldest scan inside it from the history
+var queueRateWindow = 5 * time.Minute
+
+// queueMessageStats holds the cumulative counters of a queue, they restart from zero when the queue is recreated
+type queueMessageStats struct {
+	Publish      int `json:"publish"`
+	Ack          int `json:"ack"`
+	DeliverNoAck int `json:"deliver_no_ack"`
+	GetNoAck     int `json:"get_no_ack"`
+	DeliverGet   int `json:"deliver_get"`
+	Redeliver    int `json:"redeliver"`
+}
+
+// removed counts the messages that left the queue for good
+func (s queueMessageStats) removed() int {
+	return s.Ack + s.DeliverNoAck + s.GetNoAck
+}
+
+// queueRates is the movement of a queue between the current scan and the oldest one inside the rate window
+type queueRates struct {
+	Window          float64 `json:"window_seconds"`
+	Growth          float64 `json:"growth"`
+	Ingress         float64 `json:"ingress"`
+	Egress          float64 `json:"egress"`
+	Net             float64 `json:"net"`
+	TimeToEmpty     float64 `json:"time_to_empty_seconds"`      // -1 when the queue is not shrinking
+	TimeToMaxLength float64 `json:"time_to_max_length_seconds"` // -1 when the queue is not growing or has no max-length
+}
+
+// computes the rates of every queue against the history, has to run before the scan is added to it
+func (c *cluster) queueRates(now time.Time) {
+	previous := scanHistory.oldestSince(c.ClusterName, now.Add(-queueRateWindow))
+	then := map[string]queueOutput{}
+	if previous != nil {
+		for _, q := range previous.Queues {
+			then[queueKey(q.Vhost, q.Name)] = q
+		}
+	}
+
+	for _, q := range c.Queues {
+		q.Rates = nil
+		old, exists := then[queueKey(q.Vhost, q.Name)]
+		if !exists {
+			continue
+		}
+		elapsed := now.Sub(previous.ScannedAt).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		q.Rates = q.rates(old.queue, elapsed)
+	}
+}
+
+func (q *queue) rates(old queue, elapsed float64) *queueRates {
+	r := &queueRates{Window: elapsed, TimeToEmpty: -1, TimeToMaxLength: -1}
+	r.Growth = float64(q.Messages-old.Messages) / elapsed
+	r.Ingress = counterRate(q.MessageStats.Publish, old.MessageStats.Publish, elapsed)
+	r.Egress = counterRate(q.MessageStats.removed(), old.MessageStats.removed(), elapsed)
+	r.Net = r.Ingress - r.Egress
+
+	if r.Growth < 0 && q.Messages > 0 {
+		r.TimeToEmpty = float64(q.Messages) / -r.Growth
+	}
+	// max-length only counts the ready messages
+	if limit := q.maxLength(); limit > 0 && r.Growth > 0 {
+		r.TimeToMaxLength = math.Max(0, (limit-float64(q.MessagesReady))/r.Growth)
+	}
+	return r
+}
+
+// counterRate treats a counter that went backwards as restarted from zero
+func counterRate(current, previous int, elapsed float64) float64 {
+	if current < previous {
+		return float64(current) / elapsed
+	}
+	return float64(current-previous) / elapsed
+}
+
+// maxLength returns the strictest of the x-max-length argument and the max-length of the effective policy, 0 when neither is set
+func (q *queue) maxLength() float64 {
+	limit := 0.0
+	for _, value := range []interface{}{q.Arguments["x-max-length"], q.EffectivePolicyDefinition["max-length"]} {
+		if length, ok := value.(float64); ok && length > 0 && (limit == 0 || length < limit) {
+			limit = length
+		}
+	}
+	return limit
+}
+
+func (q *queue) updateRateMetrics() {
+	if q.Rates == nil {
+		for _, name := range []string{"growth_rate", "ingress_rate", "egress_rate", "net_rate", "time_to_empty", "time_to_max_length"} {
+			queueGauges[name].Delete(q.labels())
+		}
+		return
+	}
+	queueGauges["growth_rate"].With(q.labels()).Set(q.Rates.Growth)
+	queueGauges["ingress_rate"].With(q.labels()).Set(q.Rates.Ingress)
+	queueGauges["egress_rate"].With(q.labels()).Set(q.Rates.Egress)
+	queueGauges["net_rate"].With(q.labels()).Set(q.Rates.Net)
+	if q.Rates.TimeToEmpty >= 0 {
+		queueGauges["time_to_empty"].With(q.labels()).Set(q.Rates.TimeToEmpty)
+	} else {
+		queueGauges["time_to_empty"].Delete(q.labels())
+	}
+	if q.Rates.TimeToMaxLength >= 0 {
+		queueGauges["time_to_max_length"].With(q.labels()).Set(q.Rates.TimeToMaxLength)
+	} else {
+		queueGauges["time_to_max_length"].Delete(q.labels())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import "time"
+
+// the states a queue can be in, as reported by the status gauge
+const (
+	queueOK                 = "ok"
+	queueStuck              = "stuck"                // consumers hold unacknowledged messages, or ready messages, without making any progress
+	queueStarved            = "starved"              // consumers are attached but the backlog keeps growing
+	queueSlowConsumers      = "slow_consumers"       // consumers make progress but can rarely take the ready messages right away
+	queueNoConsumersBacklog = "no_consumers_backlog" // messages are waiting and nobody consumes them
+)
+
+// a queue has to show the same lack of progress for this long before it is reported stuck or starved
+var queueStuckAfter = 5 * time.Minute
+
+// below this consumer utilisation the consumers of a queue with ready messages are the bottleneck
+var queueSlowUtilisation = 0.5
+
+// works out the status of every queue, comparing it with the last scan taken at least queueStuckAfter ago
+func (c *cluster) queueStatuses(now time.Time) {
+	previous := scanHistory.newestBefore(c.ClusterName, now.Add(-queueStuckAfter))
+	then := map[string]queueOutput{}
+	if previous != nil {
+		for _, q := range previous.Queues {
+			then[queueKey(q.Vhost, q.Name)] = q
+		}
+	}
+
+	for _, q := range c.Queues {
+		old, exists := then[queueKey(q.Vhost, q.Name)]
+		status := q.status(old.queue, exists)
+		if status != q.Status {
+			q.Status = status
+			q.StatusSince = now
+		}
+	}
+}
+
+// status classifies the queue, the stuck and starved states need the queue as it was in an older scan
+func (q *queue) status(old queue, known bool) string {
+	if q.Consumers == 0 {
+		if q.MessagesReady > 0 {
+			return queueNoConsumersBacklog
+		}
+		return queueOK
+	}
+	if !known {
+		return queueOK
+	}
+
+	acknowledged := q.MessageStats.removed() != old.MessageStats.removed()
+	if q.MessagesUnacknowledged > 0 && q.MessagesUnacknowledged == old.MessagesUnacknowledged && !acknowledged {
+		return queueStuck
+	}
+	// consumers with nothing in flight that get nothing delivered, while messages are ready
+	if q.MessagesReady > 0 && q.MessagesUnacknowledged == 0 && q.MessageStats.DeliverGet == old.MessageStats.DeliverGet {
+		return queueStuck
+	}
+	// a backlog that was already there and kept growing, the consumers can not keep up
+	if old.MessagesReady > 0 && q.MessagesReady > old.MessagesReady {
+		return queueStarved
+	}
+	// the consumers keep up with the backlog, but only just, their prefetch is full most of the time
+	if q.MessagesReady > 0 && q.ConsumerUtilisation != nil && *q.ConsumerUtilisation < queueSlowUtilisation {
+		return queueSlowConsumers
+	}
+	return queueOK
+}
+
+// exports the status as a series per queue carrying the status as a label, along with how long it has been in it
+func (q *queue) updateStatusMetrics() {
+	statusLabels := q.labels()
+	statusLabels["status"] = q.Status
+	if q.statusLabels != nil && !sameLabels(q.statusLabels, statusLabels) {
+		queueGauges["status"].Delete(q.statusLabels)
+		queueGauges["status_duration"].Delete(q.statusLabels)
+	}
+	queueGauges["status"].With(statusLabels).Set(1)
+	queueGauges["status_duration"].With(statusLabels).Set(time.Since(q.StatusSince).Seconds())
+	q.statusLabels = statusLabels
+}
@@ -1,19 +1,50 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type queue struct {
-	Consumers       int    `json:"consumers"`
-	Memory          int    `json:"memory"`
-	MessageBytes    int    `json:"message_bytes"`
-	MessageBytesRAM int    `json:"message_bytes_ram"`
-	Messages        int    `json:"messages"`
-	MessagesRAM     int    `json:"messages_ram"`
-	Name            string `json:"name"`
-	Node            string `json:"node"`
-	State           string `json:"state"`
-	Vhost           string `json:"vhost"`
-	ClusterName     string
+	Consumers              int                    `json:"consumers"`
+	Memory                 int                    `json:"memory"`
+	MessageBytes           int                    `json:"message_bytes"`
+	MessageBytesRAM        int                    `json:"message_bytes_ram"`
+	Messages               int                    `json:"messages"`
+	MessagesReady          int                    `json:"messages_ready"`
+	MessagesUnacknowledged int                    `json:"messages_unacknowledged"`
+	MessagesRAM            int                    `json:"messages_ram"`
+	ConsumerUtilisation    *float64               `json:"consumer_utilisation"` // missing when the broker has no figure for it
+	IdleSince              string                 `json:"idle_since"`
+	MessageStats           queueMessageStats      `json:"message_stats"`
+	Arguments              map[string]interface{} `json:"arguments"`
+	Name                   string                 `json:"name"`
+	Node                   string                 `json:"node"`
+	State                  string                 `json:"state"`
+	Type                   string                 `json:"type"`
+	Vhost                  string                 `json:"vhost"`
+	Policy                 string                 `json:"policy"`
+	OperatorPolicy         string                 `json:"operator_policy"`
+	ClusterName            string                 `json:"-"`
+
+	EffectivePolicyDefinition policyDefinition `json:"effective_policy_definition"`
+
+	// derived from the scans, never decoded from the api, the snapshot hands them out through queueOutput
+	Rates *queueRates `json:"-"` // computed from the previous scans, missing until there is one
+
+	Bindings int `json:"-"` // the number of bindings to the queue, not counting the default exchange
+
+	Status      string    `json:"-"` // one of ok, stuck, starved, slow_consumers or no_consumers_backlog
+	StatusSince time.Time `json:"-"` // when the queue went into its current status
+
+	LastActivity time.Time `json:"-"` // the last scan that saw the queue change, or its idle_since
+
+	grouped      bool              // the queue is only exported through its queue group
+	bindingsRead bool              // the bindings were read in the last scan, so Bindings can be trusted
+	lastLabels   prometheus.Labels // the labels used for the last update, the node changes when the queue moves
+	policyLabels prometheus.Labels // the labels used for the last policy metric
+	statusLabels prometheus.Labels // the labels used for the last status metric
 }
 
 func (q *queue) update(localQueue *queue) {
@@ -22,72 +53,104 @@ func (q *queue) update(localQueue *queue) {
 	q.MessageBytes = localQueue.MessageBytes
 	q.MessageBytesRAM = localQueue.MessageBytesRAM
 	q.Messages = localQueue.Messages
+	q.MessagesReady = localQueue.MessagesReady
+	q.MessagesUnacknowledged = localQueue.MessagesUnacknowledged
 	q.MessagesRAM = localQueue.MessagesRAM
+	q.ConsumerUtilisation = localQueue.ConsumerUtilisation
+	q.IdleSince = localQueue.IdleSince
+	q.MessageStats = localQueue.MessageStats
+	q.Arguments = localQueue.Arguments
 	q.Name = localQueue.Name
 	q.Node = localQueue.Node
 	q.State = localQueue.State
+	q.Type = localQueue.Type
 	q.Vhost = localQueue.Vhost
+	q.Policy = localQueue.Policy
+	q.OperatorPolicy = localQueue.OperatorPolicy
+	q.EffectivePolicyDefinition = localQueue.EffectivePolicyDefinition
 }
 
-func (q *queue) updateMetrics() {
-	queueGauges["consumers"].With(prometheus.Labels{
-		"cluster_name": q.ClusterName,
-		"environment":  environment,
-		"vhost":        q.Name,
-		"node":         q.Node,
-		"queue":        q.Name,
-	}).Set(float64(q.Consumers))
-	queueGauges["memory"].With(prometheus.Labels{
-		"cluster_name": q.ClusterName,
-		"environment":  environment,
-		"vhost":        q.Name,
-		"node":         q.Node,
-		"queue":        q.Name,
-	}).Set(float64(q.Memory))
-	queueGauges["message_bytes"].With(prometheus.Labels{
-		"cluster_name": q.ClusterName,
-		"environment":  environment,
-		"vhost":        q.Name,
-		"node":         q.Node,
-		"queue":        q.Name,
-	}).Set(float64(q.MessageBytes))
-	queueGauges["message_bytes_ram"].With(prometheus.Labels{
-		"cluster_name": q.ClusterName,
-		"environment":  environment,
-		"vhost":        q.Name,
-		"node":         q.Node,
-		"queue":        q.Name,
-	}).Set(float64(q.MessageBytesRAM))
-	queueGauges["messages"].With(prometheus.Labels{
-		"cluster_name": q.ClusterName,
-		"environment":  environment,
-		"vhost":        q.Name,
-		"node":         q.Node,
-		"queue":        q.Name,
-	}).Set(float64(q.Messages))
-	queueGauges["messages_ram"].With(prometheus.Labels{
+// queueKey identifies a queue inside a cluster
+func queueKey(vhost, name string) string {
+	return vhost + "/" + name
+}
+
+func (q *queue) labels() prometheus.Labels {
+	return prometheus.Labels{
 		"cluster_name": q.ClusterName,
 		"environment":  environment,
-		"vhost":        q.Name,
+		"vhost":        q.Vhost,
 		"node":         q.Node,
 		"queue":        q.Name,
-	}).Set(float64(q.MessagesRAM))
+	}
+}
+
+func (q *queue) updateMetrics() {
+	// a queue moving to another node, or a quorum queue electing a new leader, leaves the old series behind
+	if q.lastLabels != nil && !sameLabels(q.lastLabels, q.labels()) {
+		q.deleteMetrics()
+	}
+	q.lastLabels = q.labels()
+
+	queueGauges["consumers"].With(q.labels()).Set(float64(q.Consumers))
+	queueGauges["memory"].With(q.labels()).Set(float64(q.Memory))
+	queueGauges["message_bytes"].With(q.labels()).Set(float64(q.MessageBytes))
+	queueGauges["message_bytes_ram"].With(q.labels()).Set(float64(q.MessageBytesRAM))
+	queueGauges["messages"].With(q.labels()).Set(float64(q.Messages))
+	queueGauges["messages_ram"].With(q.labels()).Set(float64(q.MessagesRAM))
 	if q.State == "running" {
-		queueGauges["running"].With(prometheus.Labels{
-			"cluster_name": q.ClusterName,
-			"environment":  environment,
-			"vhost":        q.Name,
-			"node":         q.Node,
-			"queue":        q.Name,
-		}).Set(1)
+		queueGauges["running"].With(q.labels()).Set(1)
+	} else {
+		queueGauges["running"].With(q.labels()).Set(0)
+	}
+
+	q.updateRateMetrics()
+	q.updateStatusMetrics()
+	queueGauges["idle"].With(q.labels()).Set(time.Since(q.LastActivity).Seconds())
+
+	if !q.bindingsRead {
+		queueGauges["bindings"].Delete(q.labels())
+		queueGauges["unbound"].Delete(q.labels())
+	} else if q.Bindings == 0 {
+		queueGauges["bindings"].With(q.labels()).Set(0)
+		queueGauges["unbound"].With(q.labels()).Set(1)
+	} else {
+		queueGauges["bindings"].With(q.labels()).Set(float64(q.Bindings))
+		queueGauges["unbound"].With(q.labels()).Set(0)
+	}
+
+	if q.Policy != "" || q.OperatorPolicy != "" {
+		queueGauges["policy_applied"].With(q.labels()).Set(1)
 	} else {
-		queueGauges["running"].With(prometheus.Labels{
-			"cluster_name": q.ClusterName,
-			"environment":  environment,
-			"vhost":        q.Name,
-			"node":         q.Node,
-			"queue":        q.Name,
-		}).Set(0)
+		queueGauges["policy_applied"].With(q.labels()).Set(0)
+	}
+	policyLabels := q.labels()
+	policyLabels["policy"] = q.Policy
+	policyLabels["operator_policy"] = q.OperatorPolicy
+	if q.policyLabels != nil && !sameLabels(q.policyLabels, policyLabels) {
+		queueGauges["policy"].Delete(q.policyLabels)
+	}
+	queueGauges["policy"].With(policyLabels).Set(1)
+	q.policyLabels = policyLabels
+}
+
+// deleteMetrics removes every series of a queue that is no longer exported
+func (q *queue) deleteMetrics() {
+	labels := q.labels()
+	if q.lastLabels != nil {
+		labels = q.lastLabels
+	}
+	for _, gauge := range queueGauges {
+		gauge.Delete(labels)
+	}
+	if q.policyLabels != nil {
+		queueGauges["policy"].Delete(q.policyLabels)
+		q.policyLabels = nil
+	}
+	if q.statusLabels != nil {
+		queueGauges["status"].Delete(q.statusLabels)
+		queueGauges["status_duration"].Delete(q.statusLabels)
+		q.statusLabels = nil
 	}
 }
 
@@ -134,6 +197,84 @@ var queueGauges = map[string]*prometheus.GaugeVec{
 			Help: "Indicates if the current queue is running",
 		},
 		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"growth_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_growth_rate",
+			Help: "Change of the number of messages in the queue per second, smoothed over the rate window",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"ingress_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_ingress_rate",
+			Help: "Messages published into the queue per second, smoothed over the rate window",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"egress_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_egress_rate",
+			Help: "Messages removed from the queue by acknowledgements or automatic acknowledgement per second, smoothed over the rate window",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"net_rate": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_net_rate",
+			Help: "Ingress minus egress rate of the queue",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"time_to_empty": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_time_to_empty_seconds",
+			Help: "Estimated time until the queue is drained at the current rate, only while it is shrinking",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"time_to_max_length": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_time_to_max_length_seconds",
+			Help: "Estimated time until the queue reaches its max-length at the current rate, only while it is growing",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"status": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_status",
+			Help: "Set to 1 for the current status of the queue, one of ok, stuck, starved, slow_consumers or no_consumers_backlog",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment", "status"}),
+	"status_duration": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_status_duration_seconds",
+			Help: "How long the queue has been in its current status",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment", "status"}),
+	"idle": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_idle_seconds",
+			Help: "Time since the messages, message counters or consumers of the queue last changed",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"bindings": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_bindings",
+			Help: "Number of bindings to the queue, not counting the default exchange",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"unbound": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_unbound",
+			Help: "Indicates if the queue is only reachable through the default exchange",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"policy_applied": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_policy_applied",
+			Help: "Indicates if any policy or operator policy applies to the queue",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment"}),
+	"policy": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_queue_policy",
+			Help: "The effective policy and operator policy of the queue, always 1",
+		},
+		[]string{"cluster_name", "vhost", "node", "queue", "environment", "policy", "operator_policy"}),
 }
 
 func registerQueueMetrics() {
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// the collection modes of the exporter
+const (
+	collectBackground = "background" // scan every SLEEP_INTERVAL, no matter whether anyone scrapes
+	collectScrape     = "scrape"     // scan when scraped, at most once per cache ttl
+)
+
+var (
+	collectionMode = collectBackground
+	scrapeTimeout  = 10 * time.Second
+	apiTimeout     = 10 * time.Second // the timeout of every call to a cluster, SCRAPE_TIMEOUT in scrape mode
+	scrapeCacheTTL = 10 * time.Second
+	probeTargets   = map[string]string{} // the hosts /probe is allowed to scan with their cluster name, they get the configured credentials
+	probeTargetTTL = 10 * time.Minute    // a probe target not probed for that long is forgotten along with its series
+)
+
+// scrapeTarget scans a cluster on demand, the scrapes arriving while a scan runs all wait for that same scan
+type scrapeTarget struct {
+	sync.Mutex
+	cluster   *cluster
+	scannedAt time.Time
+	probedAt  time.Time     // the last time the target was asked for, only used for the probes
+	running   chan struct{} // closed when the running scan is done, nil while idle
+}
+
+// refresh scans the cluster unless the last scan is recent enough, and waits for it up to the timeout. A scan
+// that takes longer keeps going in the background and the scrape gets the state of the previous one
+func (t *scrapeTarget) refresh() {
+	t.Lock()
+	if time.Since(t.scannedAt) < scrapeCacheTTL {
+		t.Unlock()
+		return
+	}
+	if t.running == nil {
+		done := make(chan struct{})
+		t.running = done
+		go func() {
+			// a failed scan must not leave the target waiting on it forever
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("Scanning %s failed: %v", t.cluster.ClusterName, err)
+				}
+				t.Lock()
+				t.running = nil
+				t.Unlock()
+				close(done)
+			}()
+
+			t.cluster.scan()
+			alerts.evaluate(time.Now())
+
+			t.Lock()
+			t.scannedAt = time.Now()
+			t.Unlock()
+		}()
+	}
+	running := t.running
+	t.Unlock()
+
+	select {
+	case <-running:
+	case <-time.After(scrapeTimeout):
+		log.Printf("Scanning %s takes longer than %s, serving the previous scan", t.cluster.ClusterName, scrapeTimeout)
+	}
+}
+
+// scrapeTargets keeps a scrape target per probed host
+type scrapeTargets struct {
+	sync.Mutex
+	template cluster // the settings every probed cluster shares
+	byKey    map[string]*scrapeTarget
+}
+
+func newScrapeTargets(template cluster) *scrapeTargets {
+	return &scrapeTargets{template: template, byKey: map[string]*scrapeTarget{}}
+}
+
+func (s *scrapeTargets) get(address string) *scrapeTarget {
+	s.Lock()
+	defer s.Unlock()
+
+	target, exists := s.byKey[address]
+	if !exists {
+		c := s.template
+		c.Address = address
+		c.ClusterName = probeTargets[address]
+		target = &scrapeTarget{cluster: &c}
+		s.byKey[address] = target
+	}
+	target.probedAt = time.Now()
+	return target
+}
+
+// expire forgets the targets that were not probed for the ttl, with their series, so a host that stopped being
+// probed doesn't keep exporting its last scan forever
+func (s *scrapeTargets) expire() {
+	for range time.Tick(probeTargetTTL / 2) {
+		s.Lock()
+		expired := false
+		for address, target := range s.byKey {
+			target.Lock()
+			idle := target.running == nil && time.Since(target.probedAt) > probeTargetTTL
+			target.Unlock()
+			if idle {
+				forgetCluster(target.cluster.ClusterName)
+				delete(s.byKey, address)
+				expired = true
+			}
+		}
+		s.Unlock()
+		// the alerts of the forgotten clusters resolve now rather than on the next scan
+		if expired {
+			alerts.evaluate(time.Now())
+		}
+	}
+}
+
+// forgetCluster removes every series, snapshot and history entry of a cluster
+func forgetCluster(clusterName string) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		log.Println("Gathering the series to forget failed:", err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := prometheus.Labels{}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["cluster_name"] != clusterName {
+				continue
+			}
+			// a vec only deletes the series with exactly its label names, the others ignore the call
+			for _, gauges := range clusterGaugeSets() {
+				for _, gauge := range gauges {
+					gauge.Delete(labels)
+				}
+			}
+			droppedObjects.Delete(labels)
+		}
+	}
+	metricLabels.forget(clusterName)
+	snapshots.forget(clusterName)
+	scanHistory.forget(clusterName)
+}
+
+// clusterGaugeSets lists the gauges of every collector
+func clusterGaugeSets() []map[string]*prometheus.GaugeVec {
+	return []map[string]*prometheus.GaugeVec{
+		clusterGauges, overviewGauges, nodeGauges, vhostGauges, queueGauges, policyGauges, exchangeGauges,
+		shovelGauges, federationLinksGauges, federationUpstreamGauges, auditGauges, anomalyGauges, queueGroupGauges,
+	}
+}
+
+// onlyCluster keeps the series of a single cluster, and with own the series of the exporter itself, the ones
+// without a cluster
+func onlyCluster(families []*dto.MetricFamily, clusterName string, own bool) []*dto.MetricFamily {
+	kept := []*dto.MetricFamily{}
+	for _, family := range families {
+		metrics := []*dto.Metric{}
+		for _, m := range family.GetMetric() {
+			name, hasCluster := "", false
+			for _, pair := range m.GetLabel() {
+				if pair.GetName() == "cluster_name" {
+					name, hasCluster = pair.GetValue(), true
+					break
+				}
+			}
+			if name == clusterName && hasCluster || own && !hasCluster {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			kept = append(kept, family)
+		}
+	}
+	return kept
+}
+
+// scrapeHandler scans the local cluster when /metrics is scraped, the probed clusters are left to /probe
+func scrapeHandler(local *scrapeTarget) http.Handler {
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := metricLabels.Gather()
+		return onlyCluster(families, local.cluster.ClusterName, true), err
+	})
+	metrics := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local.refresh()
+		metrics.ServeHTTP(w, r)
+	})
+}
+
+// serves GET /probe?target=host&cluster_name=name with the metrics of a single cluster, scanned for the probe
+func (s *scrapeTargets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("target")
+	if address == "" {
+		http.Error(w, "target is required", http.StatusBadRequest)
+		return
+	}
+	clusterName, allowed := probeTargets[address]
+	if !allowed {
+		http.Error(w, "target "+address+" is not in PROBE_TARGETS", http.StatusForbidden)
+		return
+	}
+	// the cluster name comes from PROBE_TARGETS, a probe can't make up new ones
+	if name := r.URL.Query().Get("cluster_name"); name != "" && name != clusterName {
+		http.Error(w, "target "+address+" is the cluster "+clusterName, http.StatusBadRequest)
+		return
+	}
+
+	s.get(address).refresh()
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := metricLabels.Gather()
+		return onlyCluster(families, clusterName, false), err
+	})
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// parseProbeTargets reads a comma separated list of hosts, each optionally followed by =cluster_name and by the
+// static labels of the cluster, as in host=name;region=eu-west-1;team=platform. The cluster name defaults to the host
+func parseProbeTargets(raw string) (map[string]string, map[string]map[string]string, error) {
+	targets, labels := map[string]string{}, map[string]map[string]string{}
+	for _, target := range strings.Split(raw, ",") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		parts := strings.Split(target, ";")
+		address, clusterName := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[0])
+		if i := strings.Index(parts[0], "="); i >= 0 {
+			address, clusterName = strings.TrimSpace(parts[0][:i]), strings.TrimSpace(parts[0][i+1:])
+		}
+		static, err := parseStaticLabels(strings.Join(parts[1:], ","))
+		if err != nil {
+			return nil, nil, fmt.Errorf("target %s: %s", address, err)
+		}
+		targets[address] = clusterName
+		labels[clusterName] = static
+	}
+	return targets, labels, nil
+}
@@ -1,35 +1,139 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type shovel struct {
-	State       string `json:"state"`
-	Name        string `json:"name"`
-	Node        string `json:"node"`
-	ClusterName string
+	State            string `json:"state"`
+	Name             string `json:"name"`
+	Node             string `json:"node"`
+	Vhost            string `json:"vhost"`
+	Type             string `json:"type"` // static or dynamic
+	SrcURI           string `json:"src_uri"`
+	DestURI          string `json:"dest_uri"`
+	SrcProtocol      string `json:"src_protocol"`
+	DestProtocol     string `json:"dest_protocol"`
+	Reason           string `json:"reason"` // why the shovel was terminated
+	BlockedStatus    string `json:"blocked_status"`
+	Forwarded        int    `json:"forwarded"`
+	Pending          int    `json:"pending"`
+	Remaining        int    `json:"remaining"`
+	RemainingUnacked int    `json:"remaining_unacked"`
+	ClusterName      string `json:"-"`
+
+	lastLabels   prometheus.Labels // the labels used for the last update, the node can change between scans
+	infoLabels   prometheus.Labels
+	reasonLabels prometheus.Labels
+}
+
+// shovelKey identifies a shovel inside a cluster, dynamic shovels are unique per vhost only
+func shovelKey(vhost, name string) string {
+	return vhost + "/" + name
+}
+
+// stripCredentials removes the user information from an amqp uri so it can be safely exported
+func stripCredentials(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	parsed.User = nil
+	return parsed.String()
 }
 
 func (s *shovel) update(localShovel *shovel) {
-	s.State = localShovel.Name
+	s.State = localShovel.State
 	s.Name = localShovel.Name
 	s.Node = localShovel.Node
+	s.Vhost = localShovel.Vhost
+	s.Type = localShovel.Type
+	s.SrcURI = localShovel.SrcURI
+	s.DestURI = localShovel.DestURI
+	s.SrcProtocol = localShovel.SrcProtocol
+	s.DestProtocol = localShovel.DestProtocol
+	s.Reason = localShovel.Reason
+	s.BlockedStatus = localShovel.BlockedStatus
+	s.Forwarded = localShovel.Forwarded
+	s.Pending = localShovel.Pending
+	s.Remaining = localShovel.Remaining
+	s.RemainingUnacked = localShovel.RemainingUnacked
+}
+
+func (s *shovel) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster_name": s.ClusterName,
+		"vhost":        s.Vhost,
+		"name":         s.Name,
+		"node":         s.Node,
+		"environment":  environment,
+	}
 }
 
 func (s *shovel) updateMetrics() {
+	labels := s.labels()
+	if s.lastLabels != nil && !sameLabels(s.lastLabels, labels) {
+		s.deleteMetrics()
+	}
+	s.lastLabels = labels
+
 	if s.State == "running" {
-		shovelGauges["running"].With(prometheus.Labels{
-			"cluster_name": s.ClusterName,
-			"name":         s.Name,
-			"node":         s.Node,
-			"environment":  environment,
-		}).Set(1)
+		shovelGauges["running"].With(labels).Set(1)
 	} else {
-		shovelGauges["running"].With(prometheus.Labels{
-			"cluster_name": s.ClusterName,
-			"name":         s.Name,
-			"node":         s.Node,
-			"environment":  environment,
-		}).Set(0)
+		shovelGauges["running"].With(labels).Set(0)
+	}
+	if s.BlockedStatus == "blocked" {
+		shovelGauges["blocked"].With(labels).Set(1)
+	} else {
+		shovelGauges["blocked"].With(labels).Set(0)
+	}
+	shovelGauges["forwarded"].With(labels).Set(float64(s.Forwarded))
+	shovelGauges["pending"].With(labels).Set(float64(s.Pending))
+	shovelGauges["remaining"].With(labels).Set(float64(s.Remaining))
+	shovelGauges["remaining_unacked"].With(labels).Set(float64(s.RemainingUnacked))
+
+	infoLabels := s.labels()
+	infoLabels["type"] = s.Type
+	infoLabels["src_uri"] = stripCredentials(s.SrcURI)
+	infoLabels["dest_uri"] = stripCredentials(s.DestURI)
+	infoLabels["src_protocol"] = s.SrcProtocol
+	infoLabels["dest_protocol"] = s.DestProtocol
+	if s.infoLabels != nil && !sameLabels(s.infoLabels, infoLabels) {
+		shovelGauges["info"].Delete(s.infoLabels)
+	}
+	shovelGauges["info"].With(infoLabels).Set(1)
+	s.infoLabels = infoLabels
+
+	// the reason is only reported while the shovel is terminated
+	if s.reasonLabels != nil {
+		shovelGauges["terminated"].Delete(s.reasonLabels)
+		s.reasonLabels = nil
+	}
+	if s.State == "terminated" {
+		s.reasonLabels = s.labels()
+		s.reasonLabels["reason"] = s.Reason
+		shovelGauges["terminated"].With(s.reasonLabels).Set(1)
+	}
+}
+
+// removes all the series of a shovel that moved or does not exist anymore
+func (s *shovel) deleteMetrics() {
+	if s.lastLabels != nil {
+		for name, gauge := range shovelGauges {
+			if name != "info" && name != "terminated" {
+				gauge.Delete(s.lastLabels)
+			}
+		}
+	}
+	if s.infoLabels != nil {
+		shovelGauges["info"].Delete(s.infoLabels)
+		s.infoLabels = nil
+	}
+	if s.reasonLabels != nil {
+		shovelGauges["terminated"].Delete(s.reasonLabels)
+		s.reasonLabels = nil
 	}
 }
 
@@ -39,7 +143,49 @@ var shovelGauges = map[string]*prometheus.GaugeVec{
 			Name: "rmq_shovel_running",
 			Help: "Indicates if the current shovel is running",
 		},
-		[]string{"cluster_name", "name", "node", "environment"}),
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"blocked": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_blocked",
+			Help: "Indicates if the current shovel is blocked by flow control",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"forwarded": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_messages_forwarded",
+			Help: "Number of messages the shovel forwarded since it started",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"pending": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_messages_pending",
+			Help: "Number of messages the shovel is waiting to forward",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"remaining": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_messages_remaining",
+			Help: "Number of messages left to transfer before the shovel deletes itself",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"remaining_unacked": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_messages_remaining_unacked",
+			Help: "Number of transferred messages still waiting for an acknowledgement before the shovel deletes itself",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment"}),
+	"info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_info",
+			Help: "Type, endpoints and protocols of the shovel, always 1",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment", "type", "src_uri", "dest_uri", "src_protocol", "dest_protocol"}),
+	"terminated": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_shovel_terminated",
+			Help: "Set while the shovel is terminated, with the reason reported by the broker",
+		},
+		[]string{"cluster_name", "vhost", "name", "node", "environment", "reason"}),
 }
 
 func registerShovelMetrics() {
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshot is a copy of the state of a cluster at the end of a scan, it is never changed once taken
+type snapshot struct {
+	ClusterName     string             `json:"cluster_name"`
+	ScannedAt       time.Time          `json:"scanned_at"`
+	Overview        overview           `json:"overview"`
+	Nodes           []nodeOutput       `json:"nodes"`
+	Vhosts          []vhostOutput      `json:"vhosts"`
+	Queues          []queueOutput      `json:"queues"`
+	Exchanges       []exchangeOutput   `json:"exchanges"`
+	Policies        []policyOutput     `json:"policies"`
+	Shovels         []shovel           `json:"shovels"`
+	FederationLinks []federationLink   `json:"federation_links"`
+	QueueGroups     []queueGroup       `json:"queue_groups"`
+	Imbalance       map[string]float64 `json:"imbalance"`
+}
+
+// the objects of a snapshot are the ones decoded from the api along with what the scans derived for them. The
+// derived fields are left out of the decoding, so a key the api reports under the same name can not overwrite them
+
+type nodeOutput struct {
+	node
+	Queues        int                `json:"queues"`
+	QuorumLeaders int                `json:"quorum_leaders"`
+	QueueMessages int                `json:"queue_messages"`
+	QueueMemory   int                `json:"queue_memory"`
+	TimeToLimit   map[string]float64 `json:"time_to_limit_seconds"`
+}
+
+func (n *node) output() nodeOutput {
+	return nodeOutput{
+		node:          *n,
+		Queues:        n.Queues,
+		QuorumLeaders: n.QuorumLeaders,
+		QueueMessages: n.QueueMessages,
+		QueueMemory:   n.QueueMemory,
+		TimeToLimit:   n.TimeToLimit,
+	}
+}
+
+type vhostOutput struct {
+	vhost
+	MaxConnections   int `json:"max_connections"`
+	MaxQueues        int `json:"max_queues"`
+	Connections      int `json:"connections"`
+	TotalConnections int `json:"total_connections"`
+	Queues           int `json:"queues"`
+}
+
+func (v *vhost) output() vhostOutput {
+	return vhostOutput{
+		vhost:            *v,
+		MaxConnections:   v.MaxConnections,
+		MaxQueues:        v.MaxQueues,
+		Connections:      v.Connections,
+		TotalConnections: v.TotalConnections,
+		Queues:           v.Queues,
+	}
+}
+
+type queueOutput struct {
+	queue
+	Rates        *queueRates `json:"rates,omitempty"`
+	Bindings     int         `json:"bindings"`
+	Status       string      `json:"status"`
+	StatusSince  time.Time   `json:"status_since"`
+	LastActivity time.Time   `json:"last_activity"`
+}
+
+func (q *queue) output() queueOutput {
+	return queueOutput{
+		queue:        *q,
+		Rates:        q.Rates,
+		Bindings:     q.Bindings,
+		Status:       q.Status,
+		StatusSince:  q.StatusSince,
+		LastActivity: q.LastActivity,
+	}
+}
+
+type exchangeOutput struct {
+	exchange
+	Bindings int `json:"bindings"`
+}
+
+func (e *exchange) output() exchangeOutput {
+	return exchangeOutput{exchange: *e, Bindings: e.Bindings}
+}
+
+type policyOutput struct {
+	policy
+	Kind   string `json:"kind"`
+	Queues int    `json:"queues"`
+}
+
+func (p *policy) output() policyOutput {
+	return policyOutput{policy: *p, Kind: p.Kind, Queues: p.Queues}
+}
+
+// snapshotStore keeps the last snapshot of every cluster for the http api
+type snapshotStore struct {
+	sync.RWMutex
+	byCluster map[string]*snapshot
+}
+
+var snapshots = &snapshotStore{byCluster: map[string]*snapshot{}}
+
+// copies the current state of the cluster, sorted by name so the output is stable
+func (c *cluster) snapshot(now time.Time) *snapshot {
+	s := &snapshot{ClusterName: c.ClusterName, ScannedAt: now, Imbalance: map[string]float64{}}
+	for dimension, score := range c.Imbalance {
+		s.Imbalance[dimension] = score
+	}
+	if c.Overview != nil {
+		s.Overview = *c.Overview
+	}
+	for _, node := range c.Nodes {
+		s.Nodes = append(s.Nodes, node.output())
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Name < s.Nodes[j].Name })
+	for _, vhost := range c.Vhosts {
+		s.Vhosts = append(s.Vhosts, vhost.output())
+	}
+	sort.Slice(s.Vhosts, func(i, j int) bool { return s.Vhosts[i].Name < s.Vhosts[j].Name })
+	for _, queue := range c.Queues {
+		s.Queues = append(s.Queues, queue.output())
+	}
+	sort.Slice(s.Queues, func(i, j int) bool {
+		return s.Queues[i].Vhost+"/"+s.Queues[i].Name < s.Queues[j].Vhost+"/"+s.Queues[j].Name
+	})
+	for _, exchange := range c.Exchanges {
+		s.Exchanges = append(s.Exchanges, exchange.output())
+	}
+	sort.Slice(s.Exchanges, func(i, j int) bool {
+		return exchangeKey(s.Exchanges[i].Vhost, s.Exchanges[i].Name) < exchangeKey(s.Exchanges[j].Vhost, s.Exchanges[j].Name)
+	})
+	for _, policy := range c.Policies {
+		s.Policies = append(s.Policies, policy.output())
+	}
+	sort.Slice(s.Policies, func(i, j int) bool {
+		return policyKey(s.Policies[i].Kind, s.Policies[i].Vhost, s.Policies[i].Name) < policyKey(s.Policies[j].Kind, s.Policies[j].Vhost, s.Policies[j].Name)
+	})
+	// the uris can hold credentials, they are never handed out
+	for _, shovel := range c.Shovels {
+		copied := *shovel
+		copied.SrcURI = stripCredentials(copied.SrcURI)
+		copied.DestURI = stripCredentials(copied.DestURI)
+		s.Shovels = append(s.Shovels, copied)
+	}
+	sort.Slice(s.Shovels, func(i, j int) bool {
+		return shovelKey(s.Shovels[i].Vhost, s.Shovels[i].Name) < shovelKey(s.Shovels[j].Vhost, s.Shovels[j].Name)
+	})
+	for _, link := range c.FederationLinks {
+		copied := *link
+		copied.URI = stripCredentials(copied.URI)
+		s.FederationLinks = append(s.FederationLinks, copied)
+	}
+	sort.Slice(s.FederationLinks, func(i, j int) bool {
+		a, b := s.FederationLinks[i], s.FederationLinks[j]
+		return federationLinkKey(a.Vhost, a.Name, a.Type, a.resource()) < federationLinkKey(b.Vhost, b.Name, b.Type, b.resource())
+	})
+	for _, group := range c.QueueGroups {
+		s.QueueGroups = append(s.QueueGroups, *group)
+	}
+	sort.Slice(s.QueueGroups, func(i, j int) bool {
+		return queueGroupKey(s.QueueGroups[i].Vhost, s.QueueGroups[i].Name) < queueGroupKey(s.QueueGroups[j].Vhost, s.QueueGroups[j].Name)
+	})
+	return s
+}
+
+func (store *snapshotStore) store(s *snapshot) {
+	store.Lock()
+	defer store.Unlock()
+	store.byCluster[s.ClusterName] = s
+}
+
+func (store *snapshotStore) forget(clusterName string) {
+	store.Lock()
+	defer store.Unlock()
+	delete(store.byCluster, clusterName)
+}
+
+func (store *snapshotStore) get(clusterName string) *snapshot {
+	store.RLock()
+	defer store.RUnlock()
+	return store.byCluster[clusterName]
+}
+
+func (store *snapshotStore) all() []*snapshot {
+	store.RLock()
+	defer store.RUnlock()
+	all := make([]*snapshot, 0, len(store.byCluster))
+	for _, s := range store.byCluster {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ClusterName < all[j].ClusterName })
+	return all
+}
+
+// resource returns a single part of the snapshot by the name used in the api path
+func (s *snapshot) resource(name string) (interface{}, bool) {
+	switch name {
+	case "overview":
+		return s.Overview, true
+	case "nodes":
+		return s.Nodes, true
+	case "vhosts":
+		return s.Vhosts, true
+	case "queues":
+		return s.Queues, true
+	case "exchanges":
+		return s.Exchanges, true
+	case "policies":
+		return s.Policies, true
+	case "shovels":
+		return s.Shovels, true
+	case "federation-links":
+		return s.FederationLinks, true
+	case "queue-groups":
+		return s.QueueGroups, true
+	}
+	return nil, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// serves GET /api/v1/snapshot with the last snapshot of every cluster
+func serveSnapshots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clusters": snapshots.all()})
+}
+
+// serves GET /api/v1/clusters/{name} and /api/v1/clusters/{name}/{resource}
+func serveCluster(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/clusters/"), "/"), "/")
+	s := snapshots.get(parts[0])
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown cluster " + parts[0]})
+		return
+	}
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, s)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "history" {
+		serveHistory(w, r, s.ClusterName)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "idle-queues" {
+		serveIdleQueues(w, r, s)
+		return
+	}
+
+	resource, exists := s.resource(parts[1])
+	if len(parts) > 2 || !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown resource " + strings.Join(parts[1:], "/")})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cluster_name": s.ClusterName,
+		"scanned_at":   s.ScannedAt,
+		parts[1]:       resource,
+	})
+}
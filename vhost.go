@@ -1,20 +1,134 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type vhost struct {
-	Messages    int    `json:"messages"`
-	Name        string `json:"name"`
-	ClusterName string
+	Messages     int               `json:"messages"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Tags         tagList           `json:"tags"`
+	Tracing      bool              `json:"tracing"`
+	ClusterState map[string]string `json:"cluster_state"` // the state of the vhost on every node
+	ClusterName  string            `json:"-"`
+
+	// derived from the scans, never decoded from the api, the snapshot hands them out through vhostOutput
+	MaxConnections   int `json:"-"` // the max-connections limit, -1 when there is no limit
+	MaxQueues        int `json:"-"` // the max-queues limit, -1 when there is no limit
+	Connections      int `json:"-"` // the current number of connections to the vhost matching the connection filters
+	TotalConnections int `json:"-"` // the current number of connections to the vhost, filtered or not
+	Queues           int `json:"-"` // the current number of queues in the vhost
+
+	infoLabels prometheus.Labels // the labels used for the last info metric
+}
+
+// deleteMetrics removes every series of a vhost that is no longer exported
+func (v *vhost) deleteMetrics() {
+	labels := prometheus.Labels{"cluster_name": v.ClusterName, "environment": environment, "vhost": v.Name}
+	for _, gauge := range vhostGauges {
+		gauge.Delete(labels)
+	}
+	for node := range v.ClusterState {
+		vhostGauges["running"].Delete(prometheus.Labels{"cluster_name": v.ClusterName, "environment": environment, "vhost": v.Name, "node": node})
+	}
+	if v.infoLabels != nil {
+		vhostGauges["info"].Delete(v.infoLabels)
+	}
+}
+
+type vhostLimit struct {
+	Vhost string         `json:"vhost"`
+	Value map[string]int `json:"value"`
+}
+
+// tagList decodes tags sent either as a comma separated string (older brokers) or as a list
+type tagList []string
+
+func (t *tagList) UnmarshalJSON(data []byte) error {
+	list := []string{}
+	if err := json.Unmarshal(data, &list); err == nil {
+		*t = list
+		return nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*t = tagList{}
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
+
+func (t tagList) String() string {
+	return strings.Join(t, ",")
 }
 
 func (v *vhost) update(localVhost *vhost) {
 	v.Messages = localVhost.Messages
 	v.Name = localVhost.Name
+	v.Description = localVhost.Description
+	v.Tags = localVhost.Tags
+	v.Tracing = localVhost.Tracing
+	v.ClusterState = localVhost.ClusterState
 }
 
 func (v *vhost) updateMetrics() {
-	vhostGauges["messages"].With(prometheus.Labels{"cluster_name": v.ClusterName, "environment": environment, "vhost": v.Name}).Set(float64(v.Messages))
+	labels := prometheus.Labels{"cluster_name": v.ClusterName, "environment": environment, "vhost": v.Name}
+
+	vhostGauges["messages"].With(labels).Set(float64(v.Messages))
+	if v.Tracing {
+		vhostGauges["tracing"].With(labels).Set(1)
+	} else {
+		vhostGauges["tracing"].With(labels).Set(0)
+	}
+
+	for node, state := range v.ClusterState {
+		nodeLabels := prometheus.Labels{"cluster_name": v.ClusterName, "environment": environment, "vhost": v.Name, "node": node}
+		if state == "running" {
+			vhostGauges["running"].With(nodeLabels).Set(1)
+		} else {
+			vhostGauges["running"].With(nodeLabels).Set(0)
+		}
+	}
+
+	vhostGauges["connections"].With(labels).Set(float64(v.Connections))
+	vhostGauges["queues"].With(labels).Set(float64(v.Queues))
+	vhostGauges["max_connections"].With(labels).Set(float64(v.MaxConnections))
+	vhostGauges["max_queues"].With(labels).Set(float64(v.MaxQueues))
+
+	// the ratio only makes sense against a positive limit, otherwise drop it so alerts don't fire on stale values
+	if v.MaxConnections > 0 {
+		vhostGauges["connections_limit_ratio"].With(labels).Set(float64(v.TotalConnections) / float64(v.MaxConnections))
+	} else {
+		vhostGauges["connections_limit_ratio"].Delete(labels)
+	}
+	if v.MaxQueues > 0 {
+		vhostGauges["queues_limit_ratio"].With(labels).Set(float64(v.Queues) / float64(v.MaxQueues))
+	} else {
+		vhostGauges["queues_limit_ratio"].Delete(labels)
+	}
+
+	infoLabels := prometheus.Labels{
+		"cluster_name": v.ClusterName,
+		"environment":  environment,
+		"vhost":        v.Name,
+		"description":  v.Description,
+		"tags":         v.Tags.String(),
+	}
+	if v.infoLabels != nil && !sameLabels(v.infoLabels, infoLabels) {
+		vhostGauges["info"].Delete(v.infoLabels)
+	}
+	vhostGauges["info"].With(infoLabels).Set(1)
+	v.infoLabels = infoLabels
 }
 
 var vhostGauges = map[string]*prometheus.GaugeVec{
@@ -24,6 +138,60 @@ var vhostGauges = map[string]*prometheus.GaugeVec{
 			Help: "Current number of messages in the vhost",
 		},
 		[]string{"cluster_name", "vhost", "environment"}),
+	"tracing": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_tracing",
+			Help: "Indicates whether message tracing is enabled for the vhost",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"running": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_running",
+			Help: "Indicates whether the vhost is running on the node",
+		},
+		[]string{"cluster_name", "vhost", "node", "environment"}),
+	"connections": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_connections",
+			Help: "Current number of connections to the vhost",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_queues",
+			Help: "Current number of queues in the vhost",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"max_connections": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_max_connections",
+			Help: "The max-connections limit of the vhost, -1 when not limited",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"max_queues": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_max_queues",
+			Help: "The max-queues limit of the vhost, -1 when not limited",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"connections_limit_ratio": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_connections_limit_ratio",
+			Help: "Current connections as a ratio of the max-connections limit",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"queues_limit_ratio": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_queues_limit_ratio",
+			Help: "Current queues as a ratio of the max-queues limit",
+		},
+		[]string{"cluster_name", "vhost", "environment"}),
+	"info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rmq_vhost_info",
+			Help: "Description and tags of the vhost, always 1",
+		},
+		[]string{"cluster_name", "vhost", "environment", "description", "tags"}),
 }
 
 func registerVhostMetrics() {
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// webhook posts every alert state change to a url, using a go template over the alert as payload, for example
+// {"url": "https://chat.example.com/hook", "template": "{\"text\": {{ json .Annotations.summary }}}", "max_retries": 3, "rate_limit": 10, "rate_interval": "1m"}
+type webhook struct {
+	URL          string            `json:"url"`
+	Template     string            `json:"template"` // the alert is sent as json when there is no template
+	Headers      map[string]string `json:"headers"`
+	MaxRetries   int               `json:"max_retries"`
+	RateLimit    int               `json:"rate_limit"` // at most this many deliveries per rate interval, 0 disables the limit
+	RateInterval duration          `json:"rate_interval"`
+
+	sync.Mutex
+	tmpl       *template.Template
+	client     *http.Client
+	delivered  map[string]string // the last state delivered for each alert, so every change is only sent once
+	queued     map[string]string // the state waiting in the queue for each alert, so it isn't queued twice
+	queue      chan alert
+	deliveries []time.Time // when the deliveries inside the current rate interval happened
+}
+
+// the first retry waits this long, every following one twice as long as the previous
+var webhookBackoff = time.Second
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// reads the webhooks from a json file holding a list of them and starts their delivery workers
+func loadWebhooks(path string) ([]*webhook, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := []*webhook{}
+	if err := json.Unmarshal(contents, &webhooks); err != nil {
+		return nil, err
+	}
+
+	for _, w := range webhooks {
+		if w.URL == "" {
+			return nil, fmt.Errorf("every webhook needs an url")
+		}
+		if w.Template != "" {
+			w.tmpl, err = template.New(w.URL).Funcs(webhookFuncs).Parse(w.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: %s", w.URL, err)
+			}
+		}
+		if w.RateInterval == 0 {
+			w.RateInterval = duration(time.Minute)
+		}
+		w.client = &http.Client{Timeout: 10 * time.Second}
+		w.delivered = map[string]string{}
+		w.queued = map[string]string{}
+		w.queue = make(chan alert, 100)
+		go w.run()
+	}
+	return webhooks, nil
+}
+
+// queues the firing and resolved alerts that changed state since they were last delivered. A change that could not
+// be delivered is queued again on the next evaluation, for as long as the alert is known
+func (w *webhook) notify(alerts []alert, now time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	current := map[string]bool{}
+	for _, a := range alerts {
+		if a.State == alertPending {
+			continue
+		}
+		key := alertKey(a.Labels)
+		current[key] = true
+		if w.delivered[key] == a.State || w.queued[key] == a.State {
+			continue
+		}
+		select {
+		case w.queue <- a:
+			w.queued[key] = a.State
+		default:
+			log.Printf("Webhook %s is backed up, delaying alert %s", w.URL, a.Name)
+		}
+	}
+	for key := range w.delivered {
+		if !current[key] {
+			delete(w.delivered, key)
+		}
+	}
+}
+
+// done records the outcome of a delivery, only a delivered state is never sent again
+func (w *webhook) done(a alert, delivered bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	key := alertKey(a.Labels)
+	if w.queued[key] == a.State {
+		delete(w.queued, key)
+	}
+	if delivered {
+		w.delivered[key] = a.State
+	}
+}
+
+// delivers the queued alerts one by one
+func (w *webhook) run() {
+	for a := range w.queue {
+		if !w.allow(time.Now()) {
+			log.Printf("Webhook %s is rate limited, delaying alert %s", w.URL, a.Name)
+			w.done(a, false)
+			continue
+		}
+		payload, err := w.render(a)
+		if err != nil {
+			// the template won't render any better next time, don't try again
+			log.Printf("Rendering the payload for webhook %s failed: %s", w.URL, err)
+			w.done(a, true)
+			continue
+		}
+
+		backoff := webhookBackoff
+		for attempt := 0; ; attempt++ {
+			retry, err := w.post(payload)
+			if err == nil {
+				w.done(a, true)
+				break
+			}
+			if !retry || attempt >= w.MaxRetries {
+				log.Printf("Delivering alert %s to webhook %s failed: %s", a.Name, w.URL, err)
+				w.done(a, false)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// allow reports whether another delivery fits in the rate limit and records it
+func (w *webhook) allow(now time.Time) bool {
+	if w.RateLimit <= 0 {
+		return true
+	}
+	recent := w.deliveries[:0]
+	for _, at := range w.deliveries {
+		if now.Sub(at) < time.Duration(w.RateInterval) {
+			recent = append(recent, at)
+		}
+	}
+	w.deliveries = recent
+	if len(w.deliveries) >= w.RateLimit {
+		return false
+	}
+	w.deliveries = append(w.deliveries, now)
+	return true
+}
+
+func (w *webhook) render(a alert) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(a)
+	}
+	var out bytes.Buffer
+	if err := w.tmpl.Execute(&out, a); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// posts the payload and reports whether a failure is worth retrying
+func (w *webhook) post(payload []byte) (bool, error) {
+	request, err := http.NewRequest("POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		// the receiver will not accept the payload any better the second time, only retry its own failures
+		return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReceiver records every alert posted to it, answering with the given statuses first and 200 afterwards
+type fakeReceiver struct {
+	sync.Mutex
+	server   *httptest.Server
+	statuses []int
+	posts    int
+	alerts   []alert
+}
+
+func newFakeReceiver(t *testing.T, statuses ...int) *fakeReceiver {
+	rcv := &fakeReceiver{statuses: statuses}
+	rcv.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcv.Lock()
+		defer rcv.Unlock()
+		rcv.posts++
+		if len(rcv.statuses) > 0 {
+			status := rcv.statuses[0]
+			rcv.statuses = rcv.statuses[1:]
+			w.WriteHeader(status)
+			return
+		}
+		a := alert{}
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decoding the alert failed: %s", err)
+		}
+		rcv.alerts = append(rcv.alerts, a)
+	}))
+	t.Cleanup(rcv.server.Close)
+	return rcv
+}
+
+func (rcv *fakeReceiver) received() (int, []alert) {
+	rcv.Lock()
+	defer rcv.Unlock()
+	return rcv.posts, append([]alert{}, rcv.alerts...)
+}
+
+// startWebhook loads a single webhook the way the exporter does, which starts its delivery worker
+func startWebhook(t *testing.T, config map[string]interface{}) *webhook {
+	encoded, err := json.Marshal([]map[string]interface{}{config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	if err := ioutil.WriteFile(path, encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := loadWebhooks(path)
+	if err != nil {
+		t.Fatalf("loading the webhook failed: %s", err)
+	}
+	return webhooks[0]
+}
+
+// waitIdle waits until the worker of the webhook has nothing queued anymore
+func waitIdle(t *testing.T, w *webhook) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w.Lock()
+		queued := len(w.queued)
+		w.Unlock()
+		if queued == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("webhook %s still has alerts queued", w.URL)
+}
+
+func withAlertname(a alert, name string) alert {
+	a.Name = name
+	a.Labels = map[string]string{"alertname": name, "cluster_name": "prod"}
+	return a
+}
+
+func TestWebhookRetriesOnServerErrors(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	tests := []struct {
+		description string
+		statuses    []int
+		posts       int
+		delivered   bool
+	}{
+		{"a server error is retried", []int{http.StatusServiceUnavailable, http.StatusBadGateway}, 3, true},
+		{"too many requests is retried", []int{http.StatusTooManyRequests}, 2, true},
+		{"the retries run out", []int{500, 500, 500, 500}, 3, false},
+		{"a client error is not retried", []int{http.StatusBadRequest}, 1, false},
+	}
+
+	for _, test := range tests {
+		rcv := newFakeReceiver(t, test.statuses...)
+		w := startWebhook(t, map[string]interface{}{"url": rcv.server.URL, "max_retries": 2})
+		now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		a := firingAlert(now)
+
+		w.notify([]alert{a}, now)
+		waitIdle(t, w)
+
+		posts, received := rcv.received()
+		if posts != test.posts {
+			t.Errorf("%s: expected %d posts, got %d", test.description, test.posts, posts)
+		}
+		if delivered := len(received) == 1; delivered != test.delivered {
+			t.Errorf("%s: expected delivered %v, got %+v", test.description, test.delivered, received)
+		}
+		w.Lock()
+		state := w.delivered[alertKey(a.Labels)]
+		w.Unlock()
+		if test.delivered != (state == alertFiring) {
+			t.Errorf("%s: expected the delivered state to be recorded only on success, got %q", test.description, state)
+		}
+	}
+}
+
+func TestWebhookSendsEveryStateChangeOnce(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	w := startWebhook(t, map[string]interface{}{"url": rcv.server.URL})
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := firingAlert(now)
+
+	pending := withAlertname(a, "RabbitmqNodeDown")
+	pending.State = alertPending
+	for i := 0; i < 3; i++ {
+		w.notify([]alert{a, pending}, now.Add(time.Duration(i)*time.Second))
+		waitIdle(t, w)
+	}
+	if posts, _ := rcv.received(); posts != 1 {
+		t.Fatalf("expected the unchanged firing alert to be sent once and the pending one never, got %d posts", posts)
+	}
+
+	a.State = alertResolved
+	a.ResolvedAt = now.Add(time.Minute)
+	w.notify([]alert{a}, a.ResolvedAt)
+	waitIdle(t, w)
+	w.notify([]alert{a}, a.ResolvedAt.Add(time.Second))
+	waitIdle(t, w)
+
+	posts, received := rcv.received()
+	if posts != 2 {
+		t.Fatalf("expected the resolved state to be sent once, got %d posts", posts)
+	}
+	if received[1].State != alertResolved {
+		t.Errorf("expected the second delivery to be the resolved alert, got %+v", received[1])
+	}
+}
+
+func TestWebhookDefersTheAlertsOverTheRateLimit(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	interval := 200 * time.Millisecond
+	w := startWebhook(t, map[string]interface{}{"url": rcv.server.URL, "rate_limit": 1, "rate_interval": interval.String()})
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	first := withAlertname(firingAlert(now), "RabbitmqQueueTooLong")
+	second := withAlertname(firingAlert(now), "RabbitmqDiskAlarm")
+
+	w.notify([]alert{first, second}, now)
+	waitIdle(t, w)
+	if posts, received := rcv.received(); posts != 1 || received[0].Name != first.Name {
+		t.Fatalf("expected only the first alert to fit in the rate limit, got %d posts", posts)
+	}
+
+	// the limited alert is not dropped, the next evaluation after the interval delivers it
+	time.Sleep(interval)
+	w.notify([]alert{first, second}, now.Add(time.Second))
+	waitIdle(t, w)
+	posts, received := rcv.received()
+	if posts != 2 || received[1].Name != second.Name {
+		t.Fatalf("expected the deferred alert to be delivered once the interval passed, got %d posts", posts)
+	}
+}